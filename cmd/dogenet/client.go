@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.dogecoin.org/dogenet/internal/spec"
+	"code.dogecoin.org/dogenet/internal/web"
+)
+
+const DefaultAPI = "127.0.0.1:8085"
+const ClientTimeout = 30 * time.Second
+
+// apiClient talks to the web API of a running node.
+type apiClient struct {
+	base        string // e.g. http://127.0.0.1:8085
+	token       string // bearer token (optional)
+	fingerprint string // pinned SHA-256 certificate fingerprint (optional)
+	json        bool   // print JSON instead of tables
+	http        *http.Client
+}
+
+// clientFlags defines the flags common to all client commands.
+func clientFlags(fs *flag.FlagSet, c *apiClient) (tokenFile *string) {
+	fs.StringVar(&c.base, "api", DefaultAPI, "web API address <ip>:<port> or URL (https://<ip>:<port> for TLS)")
+	fs.StringVar(&c.token, "token", "", "web API bearer token (default: WEB_TOKEN env-var)")
+	tokenFile = fs.String("token-file", "", "<path> - read the web API bearer token from a file")
+	fs.StringVar(&c.fingerprint, "fingerprint", "", "accept the (self-signed) web API certificate with this SHA-256 fingerprint")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of a table")
+	return
+}
+
+// setup validates client flags after parsing.
+func (c *apiClient) setup(tokenFile string) error {
+	if !strings.Contains(c.base, "://") {
+		c.base = "http://" + c.base
+	}
+	c.base = strings.TrimRight(c.base, "/")
+	if _, err := url.Parse(c.base); err != nil {
+		return fmt.Errorf("bad --api: %v", err)
+	}
+	if tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return fmt.Errorf("bad --token-file: %v", err)
+		}
+		c.token = strings.TrimSpace(string(token))
+	}
+	if c.token == "" {
+		c.token = os.Getenv("WEB_TOKEN")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.fingerprint != "" {
+		want := strings.ToUpper(c.fingerprint)
+		transport.TLSClientConfig = &tls.Config{
+			// verified by fingerprint instead of a CA (self-signed certificates)
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) < 1 || web.Fingerprint(rawCerts[0]) != want {
+					return fmt.Errorf("web API certificate does not match --fingerprint")
+				}
+				return nil
+			},
+		}
+	}
+	c.http = &http.Client{Timeout: ClientTimeout, Transport: transport}
+	return nil
+}
+
+// call sends a request to the web API and returns the response body.
+func (c *apiClient) call(method string, path string, body any) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", res.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// get fetches `path` and prints it as JSON (--json) or decodes it into `v`.
+// returns false if the JSON was printed.
+func (c *apiClient) get(path string, v any) bool {
+	data, err := c.call(http.MethodGet, path, nil)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
+	}
+	if c.json {
+		var out bytes.Buffer
+		if json.Indent(&out, data, "", "  ") != nil {
+			out.Reset()
+			out.Write(data)
+		}
+		fmt.Println(strings.TrimSpace(out.String()))
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("cannot decode response: %v", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// `dogenet nodes|peers|addpeer|stats|announce` query a running node.
+func clientCommand(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	c := &apiClient{}
+	tokenFile := clientFlags(fs, c)
+	channel := ""
+	limit := 0
+	if cmd == "nodes" {
+		fs.StringVar(&channel, "channel", "", "only nodes announcing this 4-character channel")
+		fs.IntVar(&limit, "limit", 0, "maximum number of nodes to list")
+	}
+	if cmd == "announce" {
+		if len(args) < 1 || args[0] != "show" {
+			log.Printf("usage: dogenet announce show [--json] [--api <address>]")
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+	fs.Parse(args)
+	if err := c.setup(*tokenFile); err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
+	}
+	switch cmd {
+	case "nodes":
+		q := url.Values{}
+		if channel != "" {
+			q.Set("channel", channel)
+		}
+		if limit > 0 {
+			q.Set("limit", strconv.Itoa(limit))
+		}
+		path := "/nodes"
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+		var nodes []spec.NetNode
+		if c.get(path, &nodes) {
+			printNodes(nodes)
+		}
+	case "peers":
+		var peers []spec.PeerInfo
+		if c.get("/peers", &peers) {
+			printPeers(peers)
+		}
+	case "stats":
+		var stats web.Stats
+		if c.get("/stats", &stats) {
+			printStats(stats)
+		}
+	case "announce":
+		var detail spec.NodeDetail
+		if c.get("/announce", &detail) {
+			printAnnounce(detail)
+		}
+	case "addpeer":
+		if fs.NArg() != 1 {
+			log.Printf("usage: dogenet addpeer [--api <address>] <pubkey>@<ip>:<port>")
+			os.Exit(1)
+		}
+		peer, err := parsePeerArg(fs.Arg(0))
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		_, err = c.call(http.MethodPost, "/addpeer", web.AddPeer{Key: hex.EncodeToString(peer.PubKey[:]), Addr: peer.Addr.String()})
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("OK: connecting to peer %v\n", peer.Addr)
+	}
+}
+
+// Parse `<pubkey>@<ip>:<port>` (use [<ip>]:<port> for IPv6)
+func parsePeerArg(arg string) (spec.NodeInfo, error) {
+	pubHex, addrArg, found := strings.Cut(arg, "@")
+	if !found {
+		return spec.NodeInfo{}, fmt.Errorf("bad peer: expecting <pubkey>@<ip>:<port>: %v", arg)
+	}
+	pub, err := hex.DecodeString(pubHex)
+	if err != nil || len(pub) != 32 {
+		return spec.NodeInfo{}, fmt.Errorf("bad peer: invalid hex pubkey: %v", pubHex)
+	}
+	addr, err := parseIPPort(addrArg, "peer", DogeNetDefaultPort)
+	if err != nil {
+		return spec.NodeInfo{}, err
+	}
+	return spec.NodeInfo{PubKey: ([32]byte)(pub), Addr: addr}, nil
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+func printNodes(nodes []spec.NetNode) {
+	t := newTable()
+	fmt.Fprintln(t, "PUBKEY\tADDRESS\tCHANNELS\tSERVICES\tLAST SEEN")
+	for _, n := range nodes {
+		services := make([]string, 0, len(n.Services))
+		for _, svc := range n.Services {
+			services = append(services, fmt.Sprintf("%v:%v", svc.Tag, svc.Port))
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\n", n.PubKey, n.Address, orDash(strings.Join(n.Channels, ",")), orDash(strings.Join(services, ",")), ago(n.LastSeen))
+	}
+	t.Flush()
+	fmt.Printf("%v nodes\n", len(nodes))
+}
+
+func printPeers(peers []spec.PeerInfo) {
+	t := newTable()
+	fmt.Fprintln(t, "PUBKEY\tADDRESS\tDIRECTION\tCONNECTED\tMSGS IN/OUT\tBYTES IN/OUT\tRTT")
+	for _, p := range peers {
+		pub := p.PubKey
+		if !p.Handshake {
+			pub = "(handshake)"
+		}
+		rtt := "-"
+		if p.RTT > 0 {
+			rtt = fmt.Sprintf("%vms", p.RTT)
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v/%v\t%v/%v\t%v\n", orDash(pub), p.Address, p.Direction, ago(p.Connected), p.MsgsIn, p.MsgsOut, p.BytesIn, p.BytesOut, rtt)
+	}
+	t.Flush()
+	fmt.Printf("%v peers\n", len(peers))
+}
+
+func printStats(stats web.Stats) {
+	t := newTable()
+	svc := stats.Service
+	fmt.Fprintf(t, "Uptime:\t%v\n", time.Duration(svc.Uptime)*time.Second)
+	fmt.Fprintf(t, "Peers:\t%v inbound, %v outbound\n", svc.Inbound, svc.Outbound)
+	fmt.Fprintf(t, "Duplicates:\t%v dropped, %v new\n", svc.SeenHits, svc.SeenMiss)
+	nodes := stats.Nodes
+	fmt.Fprintf(t, "Nodes:\t%v (%v IPv4, %v IPv6)\n", nodes.Nodes, nodes.IPv4, nodes.IPv6)
+	age := nodes.AnnounceAge
+	fmt.Fprintf(t, "Announcements:\t%v <1h, %v <1d, %v <1w, %v <30d, %v older\n", age.Hour, age.Day, age.Week, age.Month, age.Older)
+	channels := make([]string, 0, len(nodes.Channels))
+	for ch, n := range nodes.Channels {
+		channels = append(channels, fmt.Sprintf("%v=%v", ch, n))
+	}
+	slices.Sort(channels)
+	fmt.Fprintf(t, "Channels:\t%v\n", orDash(strings.Join(channels, ", ")))
+	t.Flush()
+	if len(svc.Handlers) > 0 {
+		fmt.Println()
+		t = newTable()
+		fmt.Fprintln(t, "HANDLER\tVERSION\tCHANNELS\tCONNECTED\tMSGS IN/OUT\tDROPPED")
+		for _, h := range svc.Handlers {
+			fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v/%v\t%v\n", h.Name, orDash(h.Version), orDash(strings.Join(h.Channels, ",")), ago(h.Connected), h.MsgsIn, h.MsgsOut, h.Dropped)
+		}
+		t.Flush()
+	}
+}
+
+func printAnnounce(d spec.NodeDetail) {
+	t := newTable()
+	services := make([]string, 0, len(d.Services))
+	for _, svc := range d.Services {
+		services = append(services, fmt.Sprintf("%v:%v", svc.Tag, svc.Port))
+	}
+	fmt.Fprintf(t, "PubKey:\t%v\n", d.PubKey)
+	fmt.Fprintf(t, "Address:\t%v\n", d.Address)
+	fmt.Fprintf(t, "Signed:\t%v (%v)\n", time.Unix(d.Time, 0).Format(time.RFC3339), ago(d.Time))
+	fmt.Fprintf(t, "Owner:\t%v\n", orDash(d.Owner))
+	fmt.Fprintf(t, "Channels:\t%v\n", orDash(strings.Join(d.Channels, ", ")))
+	fmt.Fprintf(t, "Services:\t%v\n", orDash(strings.Join(services, ", ")))
+	t.Flush()
+}
+
+// ago formats a unix time as a duration before now.
+func ago(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Since(time.Unix(unix, 0)).Round(time.Second).String() + " ago"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+
+	"code.dogecoin.org/gossip/dnet"
+
+	"code.dogecoin.org/dogenet/internal/announce"
+	"code.dogecoin.org/dogenet/internal/netsvc"
+	"code.dogecoin.org/dogenet/internal/spec"
+	"code.dogecoin.org/dogenet/internal/web"
+)
+
+// Config holds all settings from command-line flags and the --config file.
+type Config struct {
+	ConfigFile     string
+	KeyFile        string
+	KeyPassFile    string
+	Dir            string
+	DBFile         string
+	AllowLocal     bool
+	StrictHandlers bool
+	UseReflector   bool
+	Limits         netsvc.Limits
+	Binds          []dnet.Address
+	Web            []webBind
+	WebAuth        web.AuthConfig
+	HandlerBind    spec.BindTo
+	Public         dnet.Address
+	Peers          []spec.NodeInfo
+}
+
+// repeatableFlags can be given more than once; in the --config file,
+// only these keys accept an array.
+var repeatableFlags = map[string]bool{"bind": true, "web": true, "web-tokens": true, "web-cors": true, "peer": true}
+
+// newFlagSet defines all flags, writing their values into cfg.
+// Every flag can also be set in the --config file, using the flag name as the key.
+func newFlagSet(cfg *Config, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	*cfg = Config{
+		DBFile:      DBFile,
+		Dir:         DefaultStorage,
+		Limits:      netsvc.DefaultLimits,
+		HandlerBind: HandlerDefaultBind,
+	}
+	fs := flag.NewFlagSet("dogenet", errorHandling)
+	fs.StringVar(&cfg.ConfigFile, "config", "", "<path> - JSON config file (flags override the file)")
+	fs.Func("dir", "<path> - storage directory (default './storage')", func(arg string) error {
+		ent, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !ent.IsDir() {
+			return fmt.Errorf("not a directory: %v", arg)
+		}
+		cfg.Dir = arg
+		return nil
+	})
+	fs.StringVar(&cfg.KeyFile, "key-file", "", "<path> - node key file (see 'dogenet key generate'; default: KEY env-var)")
+	fs.StringVar(&cfg.KeyPassFile, "key-pass-file", "", "<path> - key file passphrase (default: KEY_PASS env-var, or prompt)")
+	fs.StringVar(&cfg.DBFile, "db", DBFile, "path to SQLite database (relative: in storage dir)")
+	fs.BoolVar(&cfg.AllowLocal, "local", false, "allow local 'public' addresses (for testing)")
+	fs.Func("bind", "Bind gossip <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
+		addr, err := parseIPPort(arg, "bind", DogeNetDefaultPort)
+		if err != nil {
+			return err
+		}
+		cfg.Binds = append(cfg.Binds, addr)
+		return nil
+	})
+	fs.Func("web", "Bind web API <ip>:<port>[,cert=<file>,key=<file>|,self-signed] (use [<ip>]:<port> for IPv6)", func(arg string) error {
+		bind, err := parseWebBind(arg)
+		if err != nil {
+			return err
+		}
+		cfg.Web = append(cfg.Web, bind)
+		return nil
+	})
+	fs.Func("web-tokens", "<path> - web API bearer tokens, one `<read|admin> <token>` per line", func(arg string) error {
+		return cfg.WebAuth.LoadTokenFile(arg)
+	})
+	fs.Func("web-cors", "<origin> - allow browser pups served from this origin to use the web API ('*' for any; repeatable)", func(arg string) error {
+		cfg.WebAuth.CORSOrigins = append(cfg.WebAuth.CORSOrigins, arg)
+		return nil
+	})
+	fs.Func("handler", "Handler listen <ip>:<port> or /unix/path (use [<ip>]:<port> for IPv6)", func(arg string) error {
+		bind, err := parseBindTo(arg, "handler")
+		if err != nil {
+			return err
+		}
+		cfg.HandlerBind = bind
+		return nil
+	})
+	fs.BoolVar(&cfg.StrictHandlers, "handler-strict", false, "disconnect protocol handlers that send invalid messages")
+	fs.IntVar(&cfg.Limits.BanScore, "ban-score", netsvc.DefaultLimits.BanScore, "misbehaviour score at which a peer is banned")
+	fs.DurationVar(&cfg.Limits.BanDuration, "ban-time", netsvc.DefaultLimits.BanDuration, "how long to ban misbehaving peers")
+	fs.IntVar(&cfg.Limits.MaxInbound, "max-inbound", netsvc.DefaultLimits.MaxInbound, "maximum number of inbound peer connections")
+	fs.IntVar(&cfg.Limits.MaxPerIP, "max-per-ip", netsvc.DefaultLimits.MaxPerIP, "maximum inbound connections from one IP address")
+	fs.IntVar(&cfg.Limits.MaxPerSubnet, "max-per-subnet", netsvc.DefaultLimits.MaxPerSubnet, "maximum inbound connections from one subnet (IPv4 /16, IPv6 /32)")
+	fs.DurationVar(&cfg.Limits.HandshakeTimeout, "handshake-timeout", netsvc.DefaultLimits.HandshakeTimeout, "time allowed for peers to exchange [Node][Addr] messages")
+	fs.BoolVar(&cfg.UseReflector, "reflector", false, fmt.Sprintf("Use reflector (%s) to obtain public (ISP) address", announce.ReflectorUrl))
+	fs.Func("public", "Set public (ISP) gossip <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
+		// use DogeNetDefaultPort by default (rather than the --bind port)
+		// this is typically correct even if bind-port is something different
+		addr, err := parseIPPort(arg, "public", DogeNetDefaultPort)
+		if err != nil {
+			return err
+		}
+		cfg.Public = addr
+		return nil
+	})
+	fs.Func("peer", "<pubkey>:<ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
+		parts := strings.SplitN(arg, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("bad --peer: expecting ':' in argument: %v", arg)
+		}
+		pub, err := hex.DecodeString(parts[0])
+		if err != nil || len(pub) != 32 {
+			return fmt.Errorf("bad --peer: invalid hex pubkey: %v", parts[0])
+		}
+		addr, err := parseIPPort(parts[1], "peer", DogeNetDefaultPort)
+		if err != nil {
+			return err
+		}
+		cfg.Peers = append(cfg.Peers, spec.NodeInfo{
+			PubKey: ([32]byte)(pub),
+			Addr:   addr,
+		})
+		return nil
+	})
+	return fs
+}
+
+// loadConfig parses command-line flags and the --config file (if any),
+// applies defaults and validates the result.
+// Returns the remaining (non-flag) arguments.
+func loadConfig(args []string, errorHandling flag.ErrorHandling) (cfg Config, rest []string, err error) {
+	fs := newFlagSet(&cfg, errorHandling)
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, nil, err
+	}
+	if cfg.ConfigFile != "" {
+		err = applyConfigFile(fs, cfg.ConfigFile)
+		if err != nil {
+			return cfg, nil, err
+		}
+	}
+	return cfg, fs.Args(), cfg.validate()
+}
+
+// applyConfigFile sets flags from a JSON object, keyed by flag name.
+// Values can be strings, numbers, booleans, or arrays for repeatable flags.
+// Flags already set on the command line override keys in the file.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var keys map[string]any
+	err = dec.Decode(&keys)
+	if err != nil {
+		return fmt.Errorf("config: %v: invalid JSON: %v", path, err)
+	}
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	for key, val := range keys {
+		if fs.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("config: %v: unknown key %q", path, key)
+		}
+		if onCommandLine[key] {
+			continue // flags override the file
+		}
+		values, isArray := val.([]any)
+		if !isArray {
+			values = []any{val}
+		} else if !repeatableFlags[key] {
+			return fmt.Errorf("config: %v: key %q: does not accept an array", path, key)
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case string, json.Number, bool:
+			default:
+				return fmt.Errorf("config: %v: key %q: expecting a string, number, boolean or array", path, key)
+			}
+			err = fs.Set(key, fmt.Sprint(v))
+			if err != nil {
+				return fmt.Errorf("config: %v: key %q: %v", path, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate applies defaults and checks settings that depend on each other.
+func (cfg *Config) validate() error {
+	if len(cfg.Binds) < 1 {
+		cfg.Binds = append(cfg.Binds, dnet.Address{
+			Host: net.IP([]byte{0, 0, 0, 0}),
+			Port: DogeNetDefaultPort,
+		})
+	}
+	if len(cfg.Web) < 1 {
+		cfg.Web = append(cfg.Web, webBind{Addr: dnet.Address{
+			Host: net.IP([]byte{0, 0, 0, 0}),
+			Port: WebAPIDefaultPort,
+		}})
+	}
+	if cfg.Public.IsValid() {
+		if !cfg.AllowLocal && (!cfg.Public.Host.IsGlobalUnicast() || cfg.Public.Host.IsPrivate()) {
+			return fmt.Errorf("bad --public address: cannot be a private or multicast address")
+		}
+		cfg.UseReflector = false // valid --public IP overrides --reflector
+	} else if !cfg.UseReflector {
+		return fmt.Errorf("node public address must be specified via --public or --reflector")
+	}
+	limits := cfg.Limits
+	if limits.BanScore < 1 || limits.MaxInbound < 0 || limits.MaxPerIP < 1 || limits.MaxPerSubnet < 1 {
+		return fmt.Errorf("bad limits: --ban-score, --max-per-ip and --max-per-subnet must be at least 1, --max-inbound at least 0")
+	}
+	if limits.BanDuration <= 0 || limits.HandshakeTimeout <= 0 {
+		return fmt.Errorf("bad limits: --ban-time and --handshake-timeout must be positive")
+	}
+	return nil
+}
+
+// restartNeeded returns the keys of settings that differ from `old` and
+// cannot be changed while running.
+func (cfg *Config) restartNeeded(old *Config) (keys []string) {
+	for _, s := range []struct {
+		key     string
+		changed bool
+	}{
+		{"dir", cfg.Dir != old.Dir},
+		{"db", cfg.DBFile != old.DBFile},
+		{"key-file", cfg.KeyFile != old.KeyFile},
+		{"local", cfg.AllowLocal != old.AllowLocal},
+		{"bind", !reflect.DeepEqual(cfg.Binds, old.Binds)},
+		{"web", !reflect.DeepEqual(cfg.Web, old.Web)},
+		{"web-tokens", !reflect.DeepEqual(cfg.WebAuth.ReadTokens, old.WebAuth.ReadTokens) || !reflect.DeepEqual(cfg.WebAuth.AdminTokens, old.WebAuth.AdminTokens)},
+		{"web-cors", !reflect.DeepEqual(cfg.WebAuth.CORSOrigins, old.WebAuth.CORSOrigins)},
+		{"handler", cfg.HandlerBind != old.HandlerBind},
+		{"handler-strict", cfg.StrictHandlers != old.StrictHandlers},
+		// the reflector is only queried at startup (--public is applied live)
+		{"reflector", cfg.UseReflector && !old.UseReflector},
+	} {
+		if s.changed {
+			keys = append(keys, s.key)
+		}
+	}
+	return
+}
+
+// keepRestartSettings copies the settings that cannot be changed
+// while running from `old`.
+func (cfg *Config) keepRestartSettings(old *Config) {
+	cfg.Dir, cfg.DBFile, cfg.AllowLocal = old.Dir, old.DBFile, old.AllowLocal
+	cfg.KeyFile = old.KeyFile
+	cfg.Binds, cfg.Web, cfg.WebAuth = old.Binds, old.Web, old.WebAuth
+	cfg.HandlerBind, cfg.StrictHandlers = old.HandlerBind, old.StrictHandlers
+	cfg.UseReflector = old.UseReflector
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+var peerA = strings.Repeat("aa", 32)
+var peerB = strings.Repeat("bb", 32)
+
+func writeConfig(t *testing.T, json string) string {
+	t.Helper()
+	file := path.Join(t.TempDir(), "dogenet.json")
+	err := os.WriteFile(file, []byte(json), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		json  string   // --config file (none if empty)
+		args  []string // command-line flags (before --config)
+		err   string   // expected error substring
+		check func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "file only",
+			json: `{"public": "1.2.3.4", "max-inbound": 10, "ban-time": "1h", "local": true,
+				"bind": ["0.0.0.0:1000", "[::]:1001"], "peer": ["` + peerA + `:5.6.7.8:1234"]}`,
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Public.String() != "1.2.3.4:42069" {
+					t.Errorf("public: expecting 1.2.3.4:42069, got %v", cfg.Public)
+				}
+				if cfg.Limits.MaxInbound != 10 || cfg.Limits.BanDuration != time.Hour || !cfg.AllowLocal {
+					t.Errorf("expecting max-inbound 10, ban-time 1h and local, got %+v %v", cfg.Limits, cfg.AllowLocal)
+				}
+				if len(cfg.Binds) != 2 || cfg.Binds[0].String() != "0.0.0.0:1000" || cfg.Binds[1].String() != "[::]:1001" {
+					t.Errorf("bind: expecting both binds from the file, got %v", cfg.Binds)
+				}
+				if len(cfg.Peers) != 1 || cfg.Peers[0].PubKey[0] != 0xaa {
+					t.Errorf("peer: expecting the peer from the file, got %v", cfg.Peers)
+				}
+			},
+		},
+		{
+			name: "flags override file",
+			json: `{"public": "1.2.3.4", "max-inbound": 10,
+				"bind": ["0.0.0.0:1000", "0.0.0.0:1001"], "peer": ["` + peerA + `:5.6.7.8:1234"]}`,
+			args: []string{"--max-inbound", "5", "--bind", "127.0.0.1:2000", "--peer", peerB + ":9.9.9.9:4000"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Public.String() != "1.2.3.4:42069" {
+					t.Errorf("public: expecting the file value, got %v", cfg.Public)
+				}
+				if cfg.Limits.MaxInbound != 5 {
+					t.Errorf("max-inbound: expecting the flag value 5, got %v", cfg.Limits.MaxInbound)
+				}
+				if len(cfg.Binds) != 1 || cfg.Binds[0].String() != "127.0.0.1:2000" {
+					t.Errorf("bind: expecting only the flag value, got %v", cfg.Binds)
+				}
+				if len(cfg.Peers) != 1 || cfg.Peers[0].PubKey[0] != 0xbb {
+					t.Errorf("peer: expecting only the flag value, got %v", cfg.Peers)
+				}
+			},
+		},
+		{
+			name: "peer address",
+			args: []string{"--public", "1.2.3.4", "--peer", peerA + ":5.6.7.8:1234", "--peer", peerB + ":[2001:db8::1]:4000", "--peer", peerB + ":9.9.9.9"},
+			check: func(t *testing.T, cfg Config) {
+				want := []string{"5.6.7.8:1234", "[2001:db8::1]:4000", "9.9.9.9:42069"}
+				if len(cfg.Peers) != len(want) {
+					t.Fatalf("expecting %v peers, got %v", len(want), cfg.Peers)
+				}
+				for i, w := range want {
+					if cfg.Peers[i].Addr.String() != w {
+						t.Errorf("peer %v: expecting %v, got %v", i, w, cfg.Peers[i].Addr)
+					}
+				}
+			},
+		},
+		{name: "bad peer pubkey", args: []string{"--peer", "abcd:5.6.7.8:1234"}, err: "invalid hex pubkey"},
+		{name: "bad peer address", args: []string{"--peer", peerA + ":5.6.7:1234"}, err: "bad --peer"},
+		{name: "unknown key", json: `{"public": "1.2.3.4", "nope": 1}`, err: `unknown key "nope"`},
+		{name: "config key", json: `{"public": "1.2.3.4", "config": "other.json"}`, err: `unknown key "config"`},
+		{name: "bad number", json: `{"public": "1.2.3.4", "max-inbound": "many"}`, err: `key "max-inbound"`},
+		{name: "bad duration", json: `{"public": "1.2.3.4", "ban-time": 12}`, err: `key "ban-time"`},
+		{name: "bad peer in file", json: `{"public": "1.2.3.4", "peer": ["xyz"]}`, err: `key "peer"`},
+		{name: "object value", json: `{"public": {"ip": "1.2.3.4"}}`, err: `key "public"`},
+		{name: "array for public", json: `{"public": ["1.2.3.4", "5.6.7.8"]}`, err: `key "public": does not accept an array`},
+		{name: "array for max-inbound", json: `{"public": "1.2.3.4", "max-inbound": [1]}`, err: `key "max-inbound": does not accept an array`},
+		{name: "invalid JSON", json: `{"public": `, err: "invalid JSON"},
+		{name: "no public address", json: `{}`, err: "--public or --reflector"},
+		{name: "private public address", json: `{"public": "10.0.0.1"}`, err: "bad --public"},
+		{name: "bad limits", json: `{"public": "1.2.3.4", "max-per-ip": 0}`, err: "bad limits"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			args := c.args
+			if c.json != "" {
+				args = append(append([]string{}, args...), "--config", writeConfig(t, c.json))
+			}
+			cfg, _, err := loadConfig(args, flag.ContinueOnError)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expecting error containing %q, got: %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			c.check(t, cfg)
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	err := checkConfig(writeConfig(t, `{"public": "1.2.3.4", "peer": ["`+peerA+`:5.6.7.8:1234"]}`))
+	if err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+	err = checkConfig(writeConfig(t, `{"public": "1.2.3.4", "max-inbound": "many"}`))
+	if err == nil || !strings.Contains(err.Error(), `key "max-inbound"`) {
+		t.Errorf("invalid config: expecting an error naming the key, got: %v", err)
+	}
+	err = checkConfig(path.Join(t.TempDir(), "missing.json"))
+	if err == nil {
+		t.Errorf("missing config: expecting an error")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+
+	"code.dogecoin.org/dogenet/internal/keystore"
+	"code.dogecoin.org/dogenet/internal/spec"
+	"code.dogecoin.org/dogenet/internal/store"
+)
+
+// loadNodeKey loads the node keypair from --key-file, or the KEY env-var.
+func loadNodeKey(cfg *Config) dnet.KeyPair {
+	if cfg.KeyFile == "" {
+		return keysFromEnv()
+	}
+	if info, err := os.Stat(cfg.KeyFile); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("Warning: --key-file is accessible by other users (use chmod 600): %v", cfg.KeyFile)
+	}
+	nodeKey, err := keystore.Load(cfg.KeyFile, func() ([]byte, error) {
+		return keyPassphrase(cfg, false)
+	})
+	if err != nil {
+		log.Printf("Cannot load --key-file: %v", err)
+		os.Exit(3)
+	}
+	return nodeKey
+}
+
+func keysFromEnv() dnet.KeyPair {
+	// get the private key from the KEY env-var
+	nodeHex := os.Getenv("KEY")
+	os.Setenv("KEY", "") // don't leave the key in the environment
+	if nodeHex == "" {
+		log.Printf("Missing --key-file or KEY env-var: node public-private keypair (32 bytes; see `dogenet key generate`)")
+		os.Exit(3)
+	}
+	nodeKey, err := parseHexKey(nodeHex)
+	if err != nil {
+		log.Printf("Invalid KEY hex in env-var: %v", err)
+		os.Exit(3)
+	}
+	return nodeKey
+}
+
+func parseHexKey(nodeHex string) (dnet.KeyPair, error) {
+	nodeKey, err := hex.DecodeString(strings.TrimSpace(nodeHex))
+	if err != nil {
+		return dnet.KeyPair{}, err
+	}
+	if len(nodeKey) != 32 {
+		return dnet.KeyPair{}, fmt.Errorf("must be 32 bytes")
+	}
+	return dnet.KeyPairFromPrivKey((*[32]byte)(nodeKey)), nil
+}
+
+// keyPassphrase gets the key file passphrase from --key-pass-file,
+// the KEY_PASS env-var, or by prompting on the terminal.
+func keyPassphrase(cfg *Config, confirm bool) ([]byte, error) {
+	if cfg.KeyPassFile != "" {
+		pass, err := os.ReadFile(cfg.KeyPassFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read --key-pass-file: %v", err)
+		}
+		return bytes.TrimRight(pass, "\r\n"), nil
+	}
+	if pass := os.Getenv("KEY_PASS"); pass != "" {
+		os.Setenv("KEY_PASS", "") // don't leave the passphrase in the environment
+		return []byte(pass), nil
+	}
+	pass, err := promptPassphrase("Key passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := promptPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	return pass, nil
+}
+
+// promptPassphrase reads a line from the terminal with echo turned off.
+func promptPassphrase(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to ask for the key passphrase (use --key-pass-file or KEY_PASS)")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	if stty(tty, "-echo") == nil {
+		defer func() {
+			stty(tty, "echo")
+			fmt.Fprintln(tty)
+		}()
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("cannot read passphrase: %v", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+func stty(tty *os.File, arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
+
+// `dogenet key generate|import|rotate|show` manages the --key-file.
+func keyCommand(cfg *Config, args []string) {
+	if len(args) < 1 {
+		keyUsage()
+	}
+	fs := flag.NewFlagSet("key "+args[0], flag.ExitOnError)
+	plain := fs.Bool("plain", false, "store the private key unencrypted (no passphrase)")
+	from := fs.String("from", "", "<path> - hex private key to import ('-' for stdin; default: KEY env-var)")
+	fs.Parse(args[1:])
+	file := cfg.KeyFile
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+	if file == "" || fs.NArg() > 1 {
+		keyUsage()
+	}
+	switch args[0] {
+	case "generate":
+		nodeKey, err := dnet.GenerateKeyPair()
+		if err != nil {
+			log.Printf("Cannot generate node keypair: %v", err)
+			os.Exit(1)
+		}
+		saveKey(cfg, file, nodeKey, *plain)
+	case "import":
+		var nodeHex []byte
+		var err error
+		switch *from {
+		case "":
+			nodeHex = []byte(os.Getenv("KEY"))
+			os.Setenv("KEY", "") // don't leave the key in the environment
+		case "-":
+			nodeHex, err = bufio.NewReader(os.Stdin).ReadBytes('\n')
+			if len(nodeHex) > 0 {
+				err = nil // last line without a newline
+			}
+		default:
+			nodeHex, err = os.ReadFile(*from)
+		}
+		if err != nil {
+			log.Printf("Cannot read hex key: %v", err)
+			os.Exit(1)
+		}
+		if len(bytes.TrimSpace(nodeHex)) == 0 {
+			log.Printf("No hex key to import (use --from or the KEY env-var)")
+			os.Exit(1)
+		}
+		nodeKey, err := parseHexKey(string(nodeHex))
+		if err != nil {
+			log.Printf("Invalid hex key: %v", err)
+			os.Exit(1)
+		}
+		saveKey(cfg, file, nodeKey, *plain)
+	case "rotate":
+		rotateKey(cfg, file)
+	case "show":
+		pub, encrypted, err := keystore.PubKey(file)
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("pub: %v\n", hex.EncodeToString(pub[:]))
+		fmt.Printf("encrypted: %v\n", encrypted)
+	default:
+		keyUsage()
+	}
+}
+
+func saveKey(cfg *Config, file string, nodeKey dnet.KeyPair, plain bool) {
+	var pass []byte
+	if !plain {
+		var err error
+		pass, err = keyPassphrase(cfg, true)
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		if len(pass) == 0 {
+			log.Printf("Empty passphrase (use --plain to store the key unencrypted)")
+			os.Exit(1)
+		}
+	}
+	err := keystore.Save(file, nodeKey, pass)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("pub: %v\n", hex.EncodeToString(nodeKey.Pub[:]))
+}
+
+// rotateKey replaces the key in `file` with a new key, and stores a handover
+// message signed by the old key in the database; once restarted, the node
+// sends it to peers, who drop the old node record.
+func rotateKey(cfg *Config, file string) {
+	var pass []byte
+	oldKey, err := keystore.Load(file, func() (p []byte, err error) {
+		pass, err = keyPassphrase(cfg, false)
+		return pass, err
+	})
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
+	}
+	newKey, err := dnet.GenerateKeyPair()
+	if err != nil {
+		log.Printf("Cannot generate node keypair: %v", err)
+		os.Exit(1)
+	}
+	hand := spec.Handover{NewKey: *newKey.Pub, Time: time.Now().Unix()}
+	msg := dnet.EncodeMessageRaw(node.ChannelNode, spec.TagHandover, oldKey, hand.Encode())
+	sig := dnet.MsgView(msg.Header).Signature()
+	dbpath := path.Join(cfg.Dir, cfg.DBFile)
+	db, err := store.NewSQLiteStore(dbpath, context.Background())
+	if err != nil {
+		log.Printf("Error opening database: %v [%s]\n", err, dbpath)
+		os.Exit(1)
+	}
+	// write the new key (with the same passphrase) then swap the files,
+	// keeping the old key as <file>.old; only then store the handover,
+	// so it never names a key that was not saved.
+	if _, err := os.Stat(file + ".new"); err == nil {
+		log.Printf("Cannot write new key file: %v exists (left over from an earlier rotate? remove it)", file+".new")
+		os.Exit(1)
+	}
+	err = keystore.Save(file+".new", newKey, pass)
+	if err != nil {
+		log.Printf("Cannot write new key file: %v", err)
+		os.Exit(1)
+	}
+	err = os.Rename(file, file+".old")
+	if err == nil {
+		err = os.Rename(file+".new", file)
+		if err != nil {
+			os.Rename(file+".old", file) // restore the old key
+		}
+	}
+	if err != nil {
+		os.Remove(file + ".new")
+		log.Printf("Cannot replace key file: %v", err)
+		os.Exit(1)
+	}
+	_, err = db.SupersedeNode(oldKey.Pub[:], newKey.Pub[:], hand.Time, msg.Payload, sig[:], true)
+	if err != nil {
+		// restore the old key: nothing names the new key.
+		if rerr := os.Rename(file+".old", file); rerr != nil {
+			log.Printf("Cannot restore old key file: %v", rerr)
+		}
+		log.Printf("Cannot store key handover: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("old: %v (saved as %v)\n", hex.EncodeToString(oldKey.Pub[:]), file+".old")
+	fmt.Printf("pub: %v\n", hex.EncodeToString(newKey.Pub[:]))
+	fmt.Printf("Restart dogenet to use the new key and send the handover to peers.\n")
+}
+
+func keyUsage() {
+	log.Printf("usage: dogenet [--key-file <file>] key generate|import|rotate|show [--plain] [--from <hex-file>|-] [<file>]")
+	os.Exit(1)
+}
@@ -10,8 +10,11 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"code.dogecoin.org/gossip/dnet"
@@ -32,88 +35,10 @@ const DefaultStorage = "./storage"
 
 var HandlerDefaultBind = spec.BindTo{Network: "unix", Address: "/tmp/dogenet.sock"} // const
 
-var stderr = log.New(os.Stderr, "", 0)
-
 func main() {
-	var allowLocal bool
-	binds := []dnet.Address{}
-	bindweb := []dnet.Address{}
-	handlerBind := HandlerDefaultBind
-	public := dnet.Address{}
-	useReflector := false
-	peers := []spec.NodeInfo{}
-	dbfile := DBFile
-	dir := DefaultStorage
-	flag.Func("dir", "<path> - storage directory (default './storage')", func(arg string) error {
-		ent, err := os.Stat(arg)
-		if err != nil {
-			stderr.Fatalf("--dir: %v", err)
-		}
-		if !ent.IsDir() {
-			stderr.Fatalf("--dir: not a directory: %v", arg)
-		}
-		dir = arg
-		return nil
-	})
-	flag.StringVar(&dbfile, "db", DBFile, "path to SQLite database (relative: in storage dir)")
-	flag.BoolVar(&allowLocal, "local", false, "allow local 'public' addresses (for testing)")
-	flag.Func("bind", "Bind gossip <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
-		addr, err := parseIPPort(arg, "bind", DogeNetDefaultPort)
-		if err != nil {
-			return err
-		}
-		binds = append(binds, addr)
-		return nil
-	})
-	flag.Func("web", "Bind web API <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
-		addr, err := parseIPPort(arg, "web", WebAPIDefaultPort)
-		if err != nil {
-			return err
-		}
-		bindweb = append(bindweb, addr)
-		return nil
-	})
-	flag.Func("handler", "Handler listen <ip>:<port> or /unix/path (use [<ip>]:<port> for IPv6)", func(arg string) error {
-		bind, err := parseBindTo(arg, "handler")
-		if err != nil {
-			return err
-		}
-		handlerBind = bind
-		return nil
-	})
-	flag.BoolVar(&useReflector, "reflector", false, fmt.Sprintf("Use reflector (%s) to obtain public (ISP) address", announce.ReflectorUrl))
-	flag.Func("public", "Set public (ISP) gossip <ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
-		// use DogeNetDefaultPort by default (rather than the --bind port)
-		// this is typically correct even if bind-port is something different
-		addr, err := parseIPPort(arg, "public", DogeNetDefaultPort)
-		if err != nil {
-			return err
-		}
-		public = addr
-		return nil
-	})
-	flag.Func("peer", "<pubkey>:<ip>:<port> (use [<ip>]:<port> for IPv6)", func(arg string) error {
-		parts := strings.SplitN(arg, ":", 2)
-		if len(parts) != 2 {
-			return fmt.Errorf("bad --peer: expecting ':' in argument: %v", arg)
-		}
-		pub, err := hex.DecodeString(parts[0])
-		if err != nil || len(pub) != 32 {
-			return fmt.Errorf("bad --peer: invalid hex pubkey: %v", parts[0])
-		}
-		addr, err := parseIPPort(arg, "peer", DogeNetDefaultPort)
-		if err != nil {
-			return err
-		}
-		peers = append(peers, spec.NodeInfo{
-			PubKey: ([32]byte)(pub),
-			Addr:   addr,
-		})
-		return nil
-	})
-	flag.Parse()
-	if flag.NArg() > 0 {
-		cmd := flag.Arg(0)
+	cfg, args, err := loadConfig(os.Args[1:], flag.ExitOnError)
+	if len(args) > 0 {
+		cmd := args[0]
 		switch cmd {
 		case "genkey":
 			nodeKey, err := dnet.GenerateKeyPair()
@@ -122,76 +47,120 @@ func main() {
 			}
 			priv := hex.EncodeToString(nodeKey.Priv[:])
 			pub := hex.EncodeToString(nodeKey.Pub[:])
-			if flag.NArg() > 1 {
-				to_priv := flag.Arg(1)
-				os.WriteFile(to_priv, []byte(priv), 0666)
-				if flag.NArg() > 2 {
-					to_pub := flag.Arg(2)
-					os.WriteFile(to_pub, []byte(pub), 0666)
+			if len(args) > 1 {
+				to_priv := args[1]
+				err = os.WriteFile(to_priv, []byte(priv), 0600)
+				if err != nil {
+					log.Printf("Cannot write private key: %v", err)
+					os.Exit(1)
+				}
+				if len(args) > 2 {
+					to_pub := args[2]
+					err = os.WriteFile(to_pub, []byte(pub), 0644)
+					if err != nil {
+						log.Printf("Cannot write public key: %v", err)
+						os.Exit(1)
+					}
 				}
 			} else {
 				fmt.Printf("priv: %v\n", priv)
 				fmt.Printf("pub: %v\n", pub)
 			}
 			os.Exit(0)
+		case "key":
+			keyCommand(&cfg, args[1:])
+			os.Exit(0)
+		case "nodes", "peers", "addpeer", "stats", "announce":
+			clientCommand(cmd, args[1:])
+			os.Exit(0)
+		case "config":
+			configCommand(args[1:])
+			os.Exit(0)
 		default:
 			log.Printf("Unexpected argument: %v", cmd)
 			os.Exit(1)
 		}
 	}
-	if len(binds) < 1 {
-		binds = append(binds, dnet.Address{
-			Host: net.IP([]byte{0, 0, 0, 0}),
-			Port: DogeNetDefaultPort,
-		})
-	}
-	if len(bindweb) < 1 {
-		bindweb = append(bindweb, dnet.Address{
-			Host: net.IP([]byte{0, 0, 0, 0}),
-			Port: WebAPIDefaultPort,
-		})
-	}
-	if public.IsValid() {
-		if !allowLocal && (!public.Host.IsGlobalUnicast() || public.Host.IsPrivate()) {
-			log.Printf("bad --public address: cannot be a private or multicast address")
-			os.Exit(1)
-		}
-		useReflector = false // valid --public IP overrides --reflector
-	} else if !useReflector {
-		log.Printf("node public address must be specified via --public or --reflector")
+	if err != nil {
+		log.Printf("%v", err)
 		os.Exit(1)
 	}
 
-	// get the private key from the KEY env-var
-	nodeKey := keysFromEnv()
+	// get the private key from --key-file or the KEY env-var
+	nodeKey := loadNodeKey(&cfg)
 	log.Printf("Node PubKey is: %v", hex.EncodeToString(nodeKey.Pub[:]))
 
+	// generate or load the self-signed web API certificate, if any bind uses it.
+	// (applied to a copy, so cfg can be compared with the reloaded config)
+	webBinds := slices.Clone(cfg.Web)
+	for _, bind := range webBinds {
+		if bind.SelfSigned {
+			conf, fingerprint, err := web.SelfSigned(cfg.Dir)
+			if err != nil {
+				log.Printf("Cannot create self-signed web API certificate: %v", err)
+				os.Exit(1)
+			}
+			log.Printf("Web API certificate SHA-256 fingerprint: %v", fingerprint)
+			for i := range webBinds {
+				if webBinds[i].SelfSigned {
+					webBinds[i].TLS = conf
+				}
+			}
+			break
+		}
+	}
+
+	// web API tokens from the WEB_TOKEN (admin) and WEB_READ_TOKEN env-vars
+	webAuth := cfg.WebAuth
+	webAuth.ReadTokens = slices.Clone(webAuth.ReadTokens)
+	webAuth.AdminTokens = slices.Clone(webAuth.AdminTokens)
+	webTokensFromEnv(&webAuth)
+	if len(webAuth.AdminTokens) == 0 {
+		log.Printf("No web API admin token (see --web-tokens or WEB_TOKEN): web API is read-only")
+	}
+
 	// open the database.
-	dbpath := path.Join(dir, dbfile)
+	dbpath := path.Join(cfg.Dir, cfg.DBFile)
 	db, err := store.NewSQLiteStore(dbpath, context.Background())
 	if err != nil {
 		log.Printf("Error opening database: %v [%s]\n", err, dbpath)
 		os.Exit(1)
 	}
 
-	gov := governor.New().CatchSignals().Restart(1 * time.Second)
+	gov := governor.New().Restart(1 * time.Second)
 
 	// start the gossip server
 	changes := make(chan any, 10)
-	netSvc := netsvc.New(binds, handlerBind, nodeKey, db, allowLocal, changes)
+	netSvc := netsvc.New(cfg.Binds, cfg.HandlerBind, nodeKey, db, cfg.AllowLocal, cfg.StrictHandlers, cfg.Limits, changes)
+	netSvc.SetStaticPeers(cfg.Peers)
 	gov.Add("gossip", netSvc)
 
 	// start the announcement service
-	gov.Add("announce", announce.New(public, nodeKey, db, netSvc, changes, useReflector))
+	gov.Add("announce", announce.New(cfg.Public, nodeKey, db, netSvc, changes, cfg.UseReflector))
 
 	// start the web server.
-	for _, bind := range bindweb {
-		gov.Add("web-api", web.New(bind, db, netSvc))
+	webAPIs := []*web.WebAPI{}
+	for _, bind := range webBinds {
+		api, err := web.New(bind.Addr, db, netSvc, webAuth, bind.TLS)
+		if err != nil {
+			log.Printf("Error starting web API: %v", err)
+			os.Exit(1)
+		}
+		webAPIs = append(webAPIs, api)
+		gov.Add("web-api", api)
 	}
 
 	// start the store trimmer
 	gov.Add("store", store.NewStoreTrimmer(db))
 
+	// SIGHUP reloads the config and TLS certificates; other signals shut down.
+	catchSignals(gov, func() {
+		cfg = reloadConfig(cfg, netSvc, changes)
+		for _, api := range webAPIs {
+			api.ReloadTLS()
+		}
+	})
+
 	// run services until interrupted.
 	gov.Start()
 	gov.WaitForShutdown()
@@ -221,6 +190,42 @@ func parseIPPort(arg string, name string, defaultPort uint16) (dnet.Address, err
 	return res, nil
 }
 
+// webBind is a --web bind address with its TLS setting
+type webBind struct {
+	Addr       dnet.Address
+	TLS        web.TLSConfig
+	SelfSigned bool
+}
+
+// Parse `<ip>:<port>[,cert=<file>,key=<file>|,self-signed]`
+func parseWebBind(arg string) (bind webBind, err error) {
+	opts := strings.Split(arg, ",")
+	bind.Addr, err = parseIPPort(opts[0], "web", WebAPIDefaultPort)
+	if err != nil {
+		return bind, err
+	}
+	for _, opt := range opts[1:] {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "cert":
+			bind.TLS.CertFile = val
+		case "key":
+			bind.TLS.KeyFile = val
+		case "self-signed":
+			bind.SelfSigned = true
+		default:
+			return bind, fmt.Errorf("bad --web: unknown option: %v (expecting cert=, key= or self-signed)", opt)
+		}
+	}
+	if (bind.TLS.CertFile == "") != (bind.TLS.KeyFile == "") {
+		return bind, fmt.Errorf("bad --web: cert= and key= must be used together: %v", arg)
+	}
+	if bind.SelfSigned && bind.TLS.Enabled() {
+		return bind, fmt.Errorf("bad --web: cannot use self-signed with cert= and key=: %v", arg)
+	}
+	return bind, nil
+}
+
 func parseBindTo(arg string, name string) (spec.BindTo, error) {
 	if strings.HasPrefix(arg, "/") {
 		// unix socket path.
@@ -239,12 +244,8 @@ func parseBindTo(arg string, name string) (spec.BindTo, error) {
 			return spec.BindTo{}, fmt.Errorf("bad --%v: %v", name, err)
 		}
 		if !ent.IsDir() {
-			// exists, not a directory.
-			err = os.Remove(arg)
-			if err != nil {
-				return spec.BindTo{}, fmt.Errorf("bad --%v: cannot remove existing file: %v", name, arg)
-			}
-			// valid binding.
+			// exists, not a directory: removed by the gossip service before listening
+			// (not here, so `config check` and reload don't remove a live socket)
 			return spec.BindTo{Network: "unix", Address: arg}, nil
 		} else {
 			return spec.BindTo{}, fmt.Errorf("bad --%v: path is a directory: %v", name, arg)
@@ -262,22 +263,81 @@ func parseBindTo(arg string, name string) (spec.BindTo, error) {
 	}
 }
 
-func keysFromEnv() dnet.KeyPair {
-	// get the private key from the KEY env-var
-	nodeHex := os.Getenv("KEY")
-	os.Setenv("KEY", "") // don't leave the key in the environment
-	if nodeHex == "" {
-		log.Printf("Missing KEY env-var: node public-private keypair (32 bytes; see `dogenet genkey`)")
-		os.Exit(3)
+// `dogenet config check <file>` validates a config file without starting any services.
+func configCommand(args []string) {
+	if len(args) != 2 || args[0] != "check" {
+		log.Printf("usage: dogenet config check <file>")
+		os.Exit(1)
+	}
+	err := checkConfig(args[1])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%v: OK\n", args[1])
+}
+
+// checkConfig loads and validates a config file, without starting anything.
+func checkConfig(file string) error {
+	_, _, err := loadConfig([]string{"--config", file}, flag.ContinueOnError)
+	return err
+}
+
+func webTokensFromEnv(auth *web.AuthConfig) {
+	for _, env := range []struct{ name, scope string }{{"WEB_TOKEN", "admin"}, {"WEB_READ_TOKEN", "read"}} {
+		token := os.Getenv(env.name)
+		os.Setenv(env.name, "") // don't leave the token in the environment
+		if token == "" {
+			continue
+		}
+		err := auth.AddToken(env.scope, token)
+		if err != nil {
+			log.Printf("Invalid %v env-var: %v", env.name, err)
+			os.Exit(3)
+		}
 	}
-	nodeKey, err := hex.DecodeString(nodeHex)
+}
+
+// catchSignals calls `reload` on SIGHUP, and shuts down on other
+// interrupt signals (instead of governor's CatchSignals, which also
+// shuts down on SIGHUP)
+func catchSignals(gov governor.Governor, reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				log.Println("Reload requested via SIGHUP")
+				reload()
+				continue
+			}
+			log.Println("")
+			log.Println("Shutdown requested via signal")
+			gov.Shutdown()
+			return
+		}
+	}()
+}
+
+// reloadConfig re-reads the command-line flags and --config file,
+// applies the settings that can change while running, and logs the
+// settings that need a restart. Returns the config now in effect.
+func reloadConfig(cfg Config, netSvc *netsvc.NetService, changes chan any) Config {
+	newCfg, _, err := loadConfig(os.Args[1:], flag.ContinueOnError)
 	if err != nil {
-		log.Printf("Invalid KEY hex in env-var: %v", err)
-		os.Exit(3)
+		log.Printf("Reload failed, keeping current config: %v", err)
+		return cfg
+	}
+	netSvc.SetStaticPeers(newCfg.Peers)
+	netSvc.SetLimits(newCfg.Limits)
+	if !newCfg.UseReflector && !(newCfg.Public.Host.Equal(cfg.Public.Host) && newCfg.Public.Port == cfg.Public.Port) {
+		changes <- spec.ChangePublicAddress{Addr: newCfg.Public}
 	}
-	if len(nodeKey) != 32 {
-		log.Printf("Invalid KEY hex in env-var: must be 32 bytes")
-		os.Exit(3)
+	restart := newCfg.restartNeeded(&cfg)
+	if len(restart) > 0 {
+		log.Printf("Reload: restart needed to apply changes to: %v", strings.Join(restart, ", "))
 	}
-	return dnet.KeyPairFromPrivKey((*[32]byte)(nodeKey))
+	// keep the running values, so the next reload reports them again.
+	newCfg.keepRestartSettings(&cfg)
+	return newCfg
 }
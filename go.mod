@@ -3,11 +3,11 @@ module code.dogecoin.org/dogenet
 require (
 	code.dogecoin.org/gossip v0.0.18
 	code.dogecoin.org/governor v1.0.2
+	github.com/btcsuite/golangcrypto v0.0.0-20150304025918-53f62d9b43e8
 	github.com/mattn/go-sqlite3 v1.14.22
 )
 
 require (
-	github.com/btcsuite/golangcrypto v0.0.0-20150304025918-53f62d9b43e8 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.1.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0 // indirect
 	github.com/dogeorg/doge v0.0.12 // indirect
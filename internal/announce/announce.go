@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"slices"
+	"sync/atomic"
 	"time"
 
+	"code.dogecoin.org/dogenet/internal/events"
+	"code.dogecoin.org/dogenet/internal/metrics"
 	"code.dogecoin.org/dogenet/internal/spec"
 	"code.dogecoin.org/gossip/dnet"
 	"code.dogecoin.org/gossip/node"
@@ -28,6 +32,17 @@ const RetryRandom = 10
 
 var ZeroOwner [32]byte
 
+// unix time of the current announcement (for metrics)
+var announceTime atomic.Int64
+
+var _ = metrics.NewGaugeFunc("dogenet_announce_age_seconds", "Age of the current signed announcement.", func() float64 {
+	ts := announceTime.Load()
+	if ts == 0 {
+		return math.NaN() // no announcement yet
+	}
+	return float64(time.Now().Unix() - ts)
+})
+
 type Announce struct {
 	governor.ServiceCtx
 	_store       spec.Store
@@ -182,6 +197,7 @@ func (ns *Announce) loadOrGenerateAnnounce() (raw dnet.RawMessage, rem time.Dura
 			// re-encode the stored announcement
 			log.Printf("[announce] re-using stored announcement for %v seconds", expires-now)
 			msg := dnet.ReEncodeMessage(node.ChannelNode, node.TagAddress, ns.nodeKey.Pub, sig, oldPayload)
+			announceTime.Store(oldMsg.Time.Local().Unix())
 			return msg, time.Duration(expires-now) * time.Second, true
 		}
 	}
@@ -204,6 +220,7 @@ func (ns *Announce) generateAnnounce(newMsg node.AddressMsg) (raw dnet.RawMessag
 	// store the announcement to re-use on next startup.
 	view := dnet.MsgView(msg)
 	sig := view.Signature()[:]
+	announceTime.Store(now.Unix())
 	err := ns.store.SetAnnounce(payload, sig, now.Add(AnnounceLongevity).Unix())
 	if err != nil {
 		log.Printf("[announce] cannot store announcement: %v", err)
@@ -214,10 +231,18 @@ func (ns *Announce) generateAnnounce(newMsg node.AddressMsg) (raw dnet.RawMessag
 	nodePub := ns.nodeKey.Pub[:]
 	nodeAddr := spec.Address{Host: newMsg.Address, Port: newMsg.Port}
 	time := newMsg.Time.Local().Unix()
-	_, err = ns.store.AddNetNode(nodePub, nodeAddr, time, newMsg.Owner, newMsg.Channels, payload, sig)
+	_, err = ns.store.AddNetNode(nodePub, nodeAddr, time, newMsg.Owner, newMsg.Channels, newMsg.Services, payload, sig)
 	if err != nil {
 		log.Printf("[announce] cannot store announcement: %v", err)
 	}
+	ev := events.AnnounceEvent{Address: nodeAddr.String(), Channels: []string{}}
+	if !bytes.Equal(newMsg.Owner, ZeroOwner[:]) {
+		ev.Owner = hex.EncodeToString(newMsg.Owner)
+	}
+	for _, ch := range newMsg.Channels {
+		ev.Channels = append(ev.Channels, ch.String())
+	}
+	events.Publish(events.Announced, ev)
 
 	return dnet.RawMessage{Header: view.Header(), Payload: payload}, AnnounceLongevity, true
 }
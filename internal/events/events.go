@@ -0,0 +1,111 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.dogecoin.org/dogenet/internal/metrics"
+)
+
+// An in-process event bus for network changes (served as SSE on /events).
+// Publishers never block: each subscriber has a bounded buffer, and events
+// are dropped for a subscriber whose buffer is full.
+
+// Event types
+const (
+	NodeAdded        = "node-added"        // NodeEvent: new node stored (AddNetNode)
+	NodeUpdated      = "node-updated"      // NodeEvent: node re-announced (AddNetNode)
+	NodeExpired      = "node-expired"      // NodeEvent: node removed (TrimNodes)
+	PeerConnected    = "peer-connected"    // PeerEvent: handshake completed
+	PeerDisconnected = "peer-disconnected" // PeerEvent: connection closed after handshake
+	HandlerBound     = "handler-bound"     // HandlerEvent: handler bound a channel
+	HandlerUnbound   = "handler-unbound"   // HandlerEvent: handler unbound a channel (or disconnected)
+	Announced        = "announced"         // AnnounceEvent: this node signed a new announcement
+)
+
+const DefaultBuffer = 256 // events buffered per subscriber
+
+type Event struct {
+	Type string `json:"type"`
+	Time int64  `json:"time"` // unix time
+	Data any    `json:"data"`
+}
+
+type NodeEvent struct {
+	PubKey  string `json:"pubkey"`
+	Address string `json:"address,omitempty"` // not set for node-expired
+}
+
+type PeerEvent struct {
+	PubKey    string `json:"pubkey"`
+	Address   string `json:"address"`
+	Direction string `json:"direction"` // "inbound" or "outbound"
+}
+
+type HandlerEvent struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+}
+
+type AnnounceEvent struct {
+	Address  string   `json:"address"`
+	Owner    string   `json:"owner"`
+	Channels []string `json:"channels"`
+}
+
+var droppedEvents = metrics.NewCounter("dogenet_events_dropped_total", "Events dropped because a subscriber was too slow.")
+
+// Subscription receives published events on C until Close is called.
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of events dropped for this subscriber.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+var bus = struct {
+	mutex sync.Mutex
+	subs  map[*Subscription]bool
+}{subs: make(map[*Subscription]bool)}
+
+// Subscribe returns a new subscription with a buffer of `size` events.
+func Subscribe(size int) *Subscription {
+	ch := make(chan Event, size)
+	sub := &Subscription{C: ch, ch: ch}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subs[sub] = true
+	return sub
+}
+
+// Close stops delivery of events to the subscription.
+func (s *Subscription) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	delete(bus.subs, s)
+}
+
+// Publish sends an event to all subscribers (never blocks)
+// called from any
+func Publish(typ string, data any) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if len(bus.subs) == 0 {
+		return
+	}
+	ev := Event{Type: typ, Time: time.Now().Unix(), Data: data}
+	for sub := range bus.subs {
+		// non-blocking send to subscriber
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+			droppedEvents.Inc()
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package events
+
+import "testing"
+
+func TestPublish(t *testing.T) {
+	a := Subscribe(2)
+	defer a.Close()
+	b := Subscribe(1)
+	defer b.Close()
+
+	Publish(NodeAdded, NodeEvent{PubKey: "01"})
+	Publish(NodeExpired, NodeEvent{PubKey: "02"})
+
+	for _, want := range []string{NodeAdded, NodeExpired} {
+		ev := <-a.C
+		if ev.Type != want {
+			t.Errorf("expecting %v, got: %v", want, ev.Type)
+		}
+	}
+	if a.Dropped() != 0 {
+		t.Errorf("expecting no dropped events, got: %v", a.Dropped())
+	}
+	// the slow subscriber keeps the first event and drops the second.
+	if ev := <-b.C; ev.Type != NodeAdded || ev.Data.(NodeEvent).PubKey != "01" {
+		t.Errorf("expecting the first event, got: %+v", ev)
+	}
+	if b.Dropped() != 1 {
+		t.Errorf("expecting 1 dropped event, got: %v", b.Dropped())
+	}
+
+	// no delivery after Close
+	a.Close()
+	Publish(NodeUpdated, NodeEvent{PubKey: "03"})
+	select {
+	case ev := <-a.C:
+		t.Errorf("unexpected event after Close: %+v", ev)
+	default:
+	}
+}
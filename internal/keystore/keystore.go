@@ -0,0 +1,184 @@
+// Package keystore stores the node keypair in a file, optionally encrypted
+// with a passphrase (scrypt key derivation and AES-256-GCM.)
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"code.dogecoin.org/gossip/dnet"
+	"github.com/btcsuite/golangcrypto/scrypt"
+)
+
+const Version = 1
+const ScryptN = 1 << 17 // scrypt cost parameters for new key files (128 MiB)
+const ScryptR = 8
+const ScryptP = 1
+const SaltSize = 32
+const MaxScryptN = 1 << 20 // refuse key files that would use more than 1 GiB
+
+var ErrBadPassphrase = errors.New("wrong passphrase (or corrupt key file)")
+
+// keyFile is the JSON format of a key file.
+// Pub is stored in the clear, so the public key can be shown without the
+// passphrase; it is authenticated as additional data when decrypting.
+type keyFile struct {
+	Version int    `json:"version"`
+	Pub     string `json:"pub"`
+	Priv    string `json:"priv,omitempty"` // unencrypted key files only
+	KDF     string `json:"kdf,omitempty"`  // "scrypt"
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+	Salt    string `json:"salt,omitempty"`
+	Cipher  string `json:"cipher,omitempty"` // "aes-256-gcm"
+	Nonce   string `json:"nonce,omitempty"`
+	Data    string `json:"data,omitempty"` // encrypted private key
+}
+
+// Save writes the keypair to a new key file with mode 0600, encrypted
+// with `passphrase` unless it is empty. It will not overwrite an existing file.
+func Save(path string, key dnet.KeyPair, passphrase []byte) error {
+	kf := keyFile{Version: Version, Pub: hex.EncodeToString(key.Pub[:])}
+	if len(passphrase) == 0 {
+		kf.Priv = hex.EncodeToString(key.Priv[:])
+	} else {
+		salt := make([]byte, SaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("keystore: cannot generate salt: %v", err)
+		}
+		aead, err := newAEAD(passphrase, salt, ScryptN, ScryptR, ScryptP)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("keystore: cannot generate nonce: %v", err)
+		}
+		kf.KDF, kf.N, kf.R, kf.P = "scrypt", ScryptN, ScryptR, ScryptP
+		kf.Salt = hex.EncodeToString(salt)
+		kf.Cipher = "aes-256-gcm"
+		kf.Nonce = hex.EncodeToString(nonce)
+		kf.Data = hex.EncodeToString(aead.Seal(nil, nonce, key.Priv[:], key.Pub[:]))
+	}
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("keystore: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("keystore: %v", err)
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("keystore: cannot write %v: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads a key file; `passphrase` is only called if the file is encrypted.
+func Load(path string, passphrase func() ([]byte, error)) (dnet.KeyPair, error) {
+	kf, pub, err := readKeyFile(path)
+	if err != nil {
+		return dnet.KeyPair{}, err
+	}
+	var priv []byte
+	if kf.Data == "" {
+		priv, err = hex.DecodeString(kf.Priv)
+		if err != nil {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: invalid private key", path)
+		}
+	} else {
+		if kf.KDF != "scrypt" || kf.Cipher != "aes-256-gcm" {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: unsupported encryption: %v, %v", path, kf.KDF, kf.Cipher)
+		}
+		if kf.N > MaxScryptN || kf.R > ScryptR || kf.P > ScryptP {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: scrypt parameters are too large", path)
+		}
+		if kf.N < 2 || kf.R < 1 || kf.P < 1 {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: invalid scrypt parameters", path)
+		}
+		salt, err1 := hex.DecodeString(kf.Salt)
+		nonce, err2 := hex.DecodeString(kf.Nonce)
+		data, err3 := hex.DecodeString(kf.Data)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: invalid hex data", path)
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return dnet.KeyPair{}, err
+		}
+		aead, err := newAEAD(pass, salt, kf.N, kf.R, kf.P)
+		if err != nil {
+			return dnet.KeyPair{}, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return dnet.KeyPair{}, fmt.Errorf("keystore: %v: invalid nonce", path)
+		}
+		priv, err = aead.Open(nil, nonce, data, pub[:])
+		if err != nil {
+			return dnet.KeyPair{}, ErrBadPassphrase
+		}
+	}
+	if len(priv) != 32 {
+		return dnet.KeyPair{}, fmt.Errorf("keystore: %v: private key must be 32 bytes", path)
+	}
+	key := dnet.KeyPairFromPrivKey((*[32]byte)(priv))
+	if *key.Pub != pub {
+		return dnet.KeyPair{}, fmt.Errorf("keystore: %v: public key does not match the private key", path)
+	}
+	return key, nil
+}
+
+// PubKey reads the public key from a key file, without the passphrase.
+func PubKey(path string) (pub [32]byte, encrypted bool, err error) {
+	kf, pub, err := readKeyFile(path)
+	if err != nil {
+		return pub, false, err
+	}
+	return pub, kf.Data != "", nil
+}
+
+func readKeyFile(path string) (kf keyFile, pub [32]byte, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return kf, pub, fmt.Errorf("keystore: %v", err)
+	}
+	err = json.Unmarshal(data, &kf)
+	if err != nil {
+		return kf, pub, fmt.Errorf("keystore: %v: invalid key file: %v", path, err)
+	}
+	if kf.Version != Version {
+		return kf, pub, fmt.Errorf("keystore: %v: unsupported version: %v", path, kf.Version)
+	}
+	b, err := hex.DecodeString(kf.Pub)
+	if err != nil || len(b) != 32 {
+		return kf, pub, fmt.Errorf("keystore: %v: invalid public key", path)
+	}
+	return kf, ([32]byte)(b), nil
+}
+
+func newAEAD(passphrase []byte, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: scrypt: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,112 @@
+package keystore
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		pass string
+	}{
+		{"plain.key", ""},
+		{"encrypted.key", "correct horse battery staple"},
+	} {
+		file := path.Join(dir, c.name)
+		err = Save(file, key, []byte(c.pass))
+		if err != nil {
+			t.Fatalf("%v: Save: %v", c.name, err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%v: expecting mode 0600, got: %v", c.name, info.Mode().Perm())
+		}
+		asked := false
+		loaded, err := Load(file, func() ([]byte, error) {
+			asked = true
+			return []byte(c.pass), nil
+		})
+		if err != nil {
+			t.Fatalf("%v: Load: %v", c.name, err)
+		}
+		if *loaded.Priv != *key.Priv || *loaded.Pub != *key.Pub {
+			t.Errorf("%v: loaded key does not match", c.name)
+		}
+		if asked != (c.pass != "") {
+			t.Errorf("%v: passphrase requested: %v", c.name, asked)
+		}
+		pub, encrypted, err := PubKey(file)
+		if err != nil || pub != *key.Pub || encrypted != (c.pass != "") {
+			t.Errorf("%v: PubKey: %x %v %v", c.name, pub, encrypted, err)
+		}
+		if Save(file, key, nil) == nil {
+			t.Errorf("%v: Save must not overwrite an existing file", c.name)
+		}
+	}
+	_, err = Load(path.Join(dir, "encrypted.key"), func() ([]byte, error) {
+		return []byte("wrong"), nil
+	})
+	if err != ErrBadPassphrase {
+		t.Errorf("expecting ErrBadPassphrase, got: %v", err)
+	}
+}
+
+func TestLoadInvalidScrypt(t *testing.T) {
+	dir := t.TempDir()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "encrypted.key")
+	err = Save(file, key, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kf keyFile
+	data, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, &kf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name    string
+		n, r, p int
+	}{
+		{"n0.key", 0, ScryptR, ScryptP},
+		{"n1.key", 1, ScryptR, ScryptP},
+		{"r0.key", ScryptN, 0, ScryptP},
+		{"p0.key", ScryptN, ScryptR, 0},
+		{"negative.key", ScryptN, -1, -1},
+	} {
+		kf.N, kf.R, kf.P = c.n, c.r, c.p
+		data, err := json.Marshal(kf) // NB. omits zero fields
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad := path.Join(dir, c.name)
+		if err = os.WriteFile(bad, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err = Load(bad, func() ([]byte, error) {
+			return []byte("pass"), nil
+		})
+		if err == nil {
+			t.Errorf("%v: expecting an error for invalid scrypt parameters", c.name)
+		}
+	}
+}
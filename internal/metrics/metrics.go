@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A minimal metrics registry that writes the Prometheus text exposition
+// format, so we don't need the Prometheus client library.
+
+const MaxSeries = 256         // maximum label combinations per metric
+const OverflowLabel = "other" // label value used beyond MaxSeries
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var registry = struct {
+	mutex   sync.Mutex
+	metrics map[string]metric
+}{metrics: make(map[string]metric)}
+
+func register(name string, m metric) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if _, have := registry.metrics[name]; have {
+		panic("metrics: duplicate metric: " + name)
+	}
+	registry.metrics[name] = m
+}
+
+// WriteText writes all registered metrics in Prometheus text format.
+func WriteText(w io.Writer) {
+	registry.mutex.Lock()
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]metric, 0, len(names))
+	for _, name := range names {
+		all = append(all, registry.metrics[name])
+	}
+	registry.mutex.Unlock()
+	for _, m := range all {
+		m.write(w)
+	}
+}
+
+// CounterVec is a counter with zero or more labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	series map[string]*series // joined label values -> series (mutex)
+}
+
+type series struct {
+	values []string
+	count  atomic.Uint64
+}
+
+// NewCounter registers a counter without labels.
+func NewCounter(name string, help string) *CounterVec {
+	return NewCounterVec(name, help)
+}
+
+// NewCounterVec registers a counter with labels.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+	register(name, c)
+	return c
+}
+
+// Add adds n to the counter with the given label values.
+func (c *CounterVec) Add(n uint64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic("metrics: wrong number of label values: " + c.name)
+	}
+	key := strings.Join(values, "\x00")
+	c.mutex.Lock()
+	s, have := c.series[key]
+	if !have {
+		if len(c.series) >= MaxSeries {
+			// limit the number of series (label values can come from peers)
+			values = make([]string, len(c.labels))
+			for i := range values {
+				values[i] = OverflowLabel
+			}
+			key = strings.Join(values, "\x00")
+			s, have = c.series[key]
+		}
+		if !have {
+			s = &series{values: values}
+			c.series[key] = s
+		}
+	}
+	c.mutex.Unlock()
+	s.count.Add(n)
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	all := make([]*series, 0, len(c.series))
+	for _, s := range c.series {
+		all = append(all, s)
+	}
+	c.mutex.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\x00") < strings.Join(all[j].values, "\x00")
+	})
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(all) == 0 && len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, s := range all {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, s.values), s.count.Load())
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed when metrics are collected.
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls fn to obtain its value.
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.fn())
+}
+
+func formatLabels(labels []string, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeValue(v string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(v, "?"))
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounterVec("test_messages_total", "Test messages.", "channel", "tag")
+	c.Inc("Iden", "Prof")
+	c.Add(2, "Chat", `a"b`)
+	NewCounter("test_empty_total", "Empty counter.")
+	NewGaugeFunc("test_gauge", "Test gauge.", func() float64 { return 1.5 })
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	want := `# HELP test_empty_total Empty counter.
+# TYPE test_empty_total counter
+test_empty_total 0
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_messages_total Test messages.
+# TYPE test_messages_total counter
+test_messages_total{channel="Chat",tag="a\"b"} 2
+test_messages_total{channel="Iden",tag="Prof"} 1
+`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	c := NewCounterVec("test_series_total", "Test series.", "tag")
+	for i := 0; i < MaxSeries+10; i++ {
+		c.Inc(strings.Repeat("x", i))
+	}
+	if len(c.series) != MaxSeries+1 {
+		t.Errorf("expecting %v series (including overflow), got %v", MaxSeries+1, len(c.series))
+	}
+	if c.series[OverflowLabel].count.Load() != 10 {
+		t.Errorf("expecting 10 in the overflow series")
+	}
+}
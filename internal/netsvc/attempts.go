@@ -0,0 +1,129 @@
+package netsvc
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const MaxAttemptBackoff = 6 * time.Hour       // longest backoff after repeated connection failures
+const AttemptExpiry = 24 * time.Hour          // forget connection attempts after this long
+const AttemptPruneInterval = 10 * time.Minute // how often to prune expired attempts
+
+// attemptTracker tracks outbound connection attempts by peer pubkey.
+// Each attempt locks the pubkey for PeerLockTime; each consecutive failure
+// doubles the time before the next attempt, up to MaxAttemptBackoff.
+type attemptTracker struct {
+	mutex     sync.Mutex
+	attempts  map[MapPubKey]*attempt // (mutex)
+	lastPrune time.Time              // (mutex)
+}
+
+type attempt struct {
+	failures int       // consecutive failures
+	last     time.Time // time of the last attempt
+	until    time.Time // no further attempts until this time
+}
+
+func newAttemptTracker() *attemptTracker {
+	return &attemptTracker{
+		attempts:  make(map[MapPubKey]*attempt),
+		lastPrune: time.Now(),
+	}
+}
+
+// lock reserves a peer pubkey for a connection attempt;
+// returns false if the pubkey is locked or backing off.
+// called from attractPeers
+func (t *attemptTracker) lock(pubKey MapPubKey) bool {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if now.Sub(t.lastPrune) > AttemptPruneInterval {
+		t.prune(now)
+	}
+	a, have := t.attempts[pubKey]
+	if have && now.Before(a.until) {
+		return false // still locked
+	}
+	if !have {
+		a = &attempt{}
+		t.attempts[pubKey] = a
+	}
+	a.last = now
+	a.until = now.Add(PeerLockTime)
+	return true
+}
+
+// isLocked returns true if the pubkey is locked or backing off.
+// called from attractPeers
+func (t *attemptTracker) isLocked(pubKey MapPubKey) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	a, have := t.attempts[pubKey]
+	return have && time.Now().Before(a.until)
+}
+
+// failed records a failed connection attempt and backs off exponentially.
+// called from any
+func (t *attemptTracker) failed(pubKey MapPubKey) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	a, have := t.attempts[pubKey]
+	if !have {
+		a = &attempt{last: now}
+		t.attempts[pubKey] = a
+	}
+	a.failures++
+	backoff := MaxAttemptBackoff
+	if a.failures < 32 && PeerLockTime<<(a.failures-1) < MaxAttemptBackoff {
+		backoff = PeerLockTime << (a.failures - 1)
+	}
+	a.until = now.Add(backoff)
+}
+
+// succeeded records a successful connection, which resets the backoff.
+// called from any
+func (t *attemptTracker) succeeded(pubKey MapPubKey) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.attempts, pubKey)
+}
+
+// chance randomly passes over peers that have failed before:
+// a peer with N consecutive failures is chosen with probability 1/(N+1).
+// called from attractPeers
+func (t *attemptTracker) chance(pubKey MapPubKey) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if a, have := t.attempts[pubKey]; have && a.failures > 0 {
+		return rand.Intn(a.failures+1) == 0
+	}
+	return true
+}
+
+func (t *attemptTracker) prune(now time.Time) {
+	for key, a := range t.attempts {
+		if now.Sub(a.last) > AttemptExpiry && now.After(a.until) {
+			delete(t.attempts, key)
+		}
+	}
+	t.lastPrune = now
+}
+
+// recordAttempt records the outcome of an outbound connection attempt,
+// in the attempt tracker and in the node's stored connection history.
+// called from any
+func (ns *NetService) recordAttempt(pubKey MapPubKey, ok bool) {
+	if ok {
+		ns.attempts.succeeded(pubKey)
+	} else {
+		ns.attempts.failed(pubKey)
+	}
+	err := ns.store.RecordConnect(pubKey[:], ok)
+	if err != nil {
+		log.Printf("[%s] RecordConnect: %v", ns.ServiceName, err)
+	}
+}
@@ -2,32 +2,48 @@ package netsvc
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+	"unicode"
 
+	"code.dogecoin.org/dogenet/internal/events"
 	"code.dogecoin.org/dogenet/internal/spec"
+	"code.dogecoin.org/gossip/codec"
 	"code.dogecoin.org/gossip/dnet"
 )
 
 type handlerConn struct {
-	ns      *NetService
-	conn    net.Conn
-	channel uint32 // for atomic.Load
-	receive map[dnet.Tag4CC]chan dnet.Message
-	send    chan dnet.RawMessage
-	name    string
+	ns          *NetService
+	conn        net.Conn
+	receive     map[dnet.Tag4CC]chan dnet.Message
+	send        chan dnet.RawMessage
+	connectedAt time.Time // [const] time the handler connected
+	msgsIn      atomic.Uint64
+	msgsOut     atomic.Uint64
+	dropped     atomic.Uint64 // messages dropped by forwardToHandlers
+	mutex       sync.Mutex
+	name        string               // set by [DNet][Name] (mutex; written on receiveFromHandler)
+	version     string               // set by [DNet][Name] (mutex)
+	channels    map[dnet.Tag4CC]bool // set of bound channels (mutex)
 }
 
 func newHandler(conn net.Conn, ns *NetService) *handlerConn {
 	hand := &handlerConn{
-		ns:      ns,
-		conn:    conn,
-		receive: make(map[dnet.Tag4CC]chan dnet.Message),
-		send:    make(chan dnet.RawMessage),
-		name:    "protocol-handler",
+		ns:          ns,
+		conn:        conn,
+		receive:     make(map[dnet.Tag4CC]chan dnet.Message),
+		send:        make(chan dnet.RawMessage),
+		connectedAt: time.Now(),
+		name:        "protocol-handler",
+		channels:    make(map[dnet.Tag4CC]bool),
 	}
 	return hand
 }
@@ -46,23 +62,12 @@ func (hand *handlerConn) receiveFromHandler() {
 		hand.ns.closeHandler(hand)
 		return
 	}
-	// set our channel so forwardToHandlers will start sending us messages.
-	atomic.StoreUint32(&hand.channel, uint32(bind.Chan))
-	log.Printf("[%s] handler bound to channel: [%v]", hand.name, bind.Chan)
-	// add the channel in the database (or update time)
-	store := hand.ns.store
-	err = store.AddChannel(bind.Chan)
+	err = hand.bindChannel(bind)
 	if err != nil {
 		log.Println(err.Error())
 		hand.ns.closeHandler(hand)
 		return
 	}
-	// forward the owner pubkey to the announce service
-	// only when received from the [Iden] pup
-	if bind.Chan == dnet.ChannelIdentity {
-		hand.ns.announceChanges <- spec.ChangeOwnerKey{Key: &bind.PubKey}
-		hand.ns.announceChanges <- spec.ChangeChannel{Chan: bind.Chan}
-	}
 	// send bind message in reply, with this node's pubkey
 	reply := dnet.BindMessage{Version: 1, Chan: bind.Chan, PubKey: *hand.ns.nodeKey.Pub}
 	_, err = hand.conn.Write(reply.Encode())
@@ -77,14 +82,245 @@ func (hand *handlerConn) receiveFromHandler() {
 	for !hand.ns.Stopping() {
 		msg, err := dnet.ReadMessage(reader)
 		if err != nil {
+			if strings.Contains(err.Error(), "signature") {
+				// the whole message was read: OK to continue reading.
+				if hand.reject(err) {
+					return
+				}
+				continue
+			}
 			log.Printf("[%s] cannot receive from handler: %v", hand.name, err)
 			hand.ns.closeHandler(hand)
 			return
 		}
-		// forward the message to all peers (ignore channel here)
 		log.Printf("[%s] received from handler: [%v][%v]", hand.name, msg.Chan, msg.Tag)
-		hand.ns.forwardToPeers(dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload})
+		hand.msgsIn.Add(1)
+		if msg.Chan == spec.ChannelDogeNet {
+			// control message for DogeNet (never forwarded)
+			err = hand.controlMessage(msg)
+			if err != nil && hand.reject(err) {
+				return
+			}
+			continue
+		}
+		// only forward messages on channels the handler is bound to.
+		err = hand.checkChannel(msg.Chan, msg.Tag)
+		if err != nil {
+			if hand.reject(err) {
+				return
+			}
+			continue
+		}
+		// forward the message to all peers (except its origin, if received from a peer)
+		// remember the message, so we don't deliver it to handlers if a peer echoes it.
+		from, ok := hand.ns.seen.Relay(msg.Signature, true)
+		if !ok {
+			log.Printf("[%s] dropped duplicate message from handler: [%v][%v]", hand.name, msg.Chan, msg.Tag)
+			continue
+		}
+		hand.ns.forwardToPeers(dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}, from)
+	}
+}
+
+// bindChannel subscribes the handler to a channel.
+// runs on receiveFromHandler
+func (hand *handlerConn) bindChannel(bind dnet.BindMessage) error {
+	if bind.Chan == dnet.ChannelNode || bind.Chan == spec.ChannelDogeNet {
+		return fmt.Errorf("[%s] cannot bind to reserved channel: [%v]", hand.name, bind.Chan)
+	}
+	// add the channel so forwardToHandlers will start sending us messages.
+	if !hand.addChannel(bind.Chan) {
+		log.Printf("[%s] handler already bound to channel: [%v]", hand.name, bind.Chan)
+		return nil
+	}
+	log.Printf("[%s] handler bound to channel: [%v]", hand.name, bind.Chan)
+	events.Publish(events.HandlerBound, events.HandlerEvent{Name: hand.name, Channel: bind.Chan.String()})
+	// add the channel in the database (or update time)
+	err := hand.ns.store.AddChannel(bind.Chan)
+	if err != nil {
+		return err
+	}
+	// forward the owner pubkey to the announce service
+	// only when received from the [Iden] pup
+	if bind.Chan == dnet.ChannelIdentity {
+		hand.ns.announceChanges <- spec.ChangeOwnerKey{Key: &bind.PubKey}
+	}
+	// update the channels in our announcement
+	hand.ns.announceChanges <- spec.ChangeChannel{Chan: bind.Chan}
+	return nil
+}
+
+// called from any
+func (hand *handlerConn) addChannel(channel dnet.Tag4CC) bool {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	if hand.channels[channel] {
+		return false
+	}
+	hand.channels[channel] = true
+	return true
+}
+
+// called from any
+func (hand *handlerConn) removeChannel(channel dnet.Tag4CC) bool {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	if !hand.channels[channel] {
+		return false
+	}
+	delete(hand.channels, channel)
+	return true
+}
+
+// isBound returns true if the handler is bound to the channel.
+// called from any
+func (hand *handlerConn) isBound(channel dnet.Tag4CC) bool {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	return hand.channels[channel]
+}
+
+// boundChannels returns the set of channels the handler is bound to.
+// called from any
+func (hand *handlerConn) boundChannels() (channels []dnet.Tag4CC) {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	for ch := range hand.channels {
+		channels = append(channels, ch)
+	}
+	return
+}
+
+// setName sets the handler name and version, from [DNet][Name]
+// runs on receiveFromHandler
+func (hand *handlerConn) setName(payload []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil { // for codec.Decoder
+			err = fmt.Errorf("invalid name message: %v", e)
+		}
+	}()
+	dec := codec.Decode(payload)
+	name := dec.VarString()
+	version := dec.VarString()
+	if name == "" || len(name) > spec.MaxHandlerName || len(version) > spec.MaxHandlerVersion {
+		return fmt.Errorf("invalid name message: name or version too long (or empty name)")
+	}
+	if !isPrintable(name) || !isPrintable(version) {
+		return fmt.Errorf("invalid name message: non-printable characters")
+	}
+	log.Printf("[%s] handler identified as: %v %v", hand.name, name, version)
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	hand.name = name
+	hand.version = version
+	return nil
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// getName returns the handler name (for logs on other goroutines)
+// called from any
+func (hand *handlerConn) getName() string {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	return hand.name
+}
+
+// info returns a snapshot of the handler state.
+// called from any
+func (hand *handlerConn) info() spec.HandlerInfo {
+	channels := []string{}
+	for _, ch := range hand.boundChannels() {
+		channels = append(channels, ch.String())
+	}
+	sort.Strings(channels)
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+	return spec.HandlerInfo{
+		Name:      hand.name,
+		Version:   hand.version,
+		Channels:  channels,
+		Connected: hand.connectedAt.Unix(),
+		MsgsIn:    hand.msgsIn.Load(),
+		MsgsOut:   hand.msgsOut.Load(),
+		Dropped:   hand.dropped.Load(),
+	}
+}
+
+// checkChannel verifies that a handler message can be forwarded to peers:
+// never on the [Node] channel, and only on channels the handler is bound to.
+func (hand *handlerConn) checkChannel(channel dnet.Tag4CC, tag dnet.Tag4CC) error {
+	if channel == dnet.ChannelNode || channel == spec.ChannelDogeNet {
+		return fmt.Errorf("message on reserved channel: [%v][%v]", channel, tag)
+	}
+	if !hand.isBound(channel) {
+		return fmt.Errorf("message on unbound channel: [%v][%v]", channel, tag)
+	}
+	return nil
+}
+
+// reject logs the reason a handler message was rejected, and closes the
+// handler connection if --handler-strict is set; returns true if closed.
+// runs on receiveFromHandler
+func (hand *handlerConn) reject(reason error) bool {
+	log.Printf("[%s] rejected message from handler: %v", hand.name, reason)
+	if hand.ns.strictHandlers {
+		log.Printf("[%s] disconnecting handler (strict mode)", hand.name)
+		hand.ns.closeHandler(hand)
+		return true
+	}
+	return false
+}
+
+// runs on receiveFromHandler
+func (hand *handlerConn) controlMessage(msg dnet.Message) error {
+	switch msg.Tag {
+	case spec.TagBind:
+		bind, ok := dnet.DecodeBindMessage(msg.Payload)
+		if !ok {
+			return fmt.Errorf("invalid bind message (wrong size)")
+		}
+		return hand.bindChannel(bind)
+	case spec.TagUnbind:
+		if len(msg.Payload) != 4 {
+			return fmt.Errorf("invalid unbind message (wrong size)")
+		}
+		channel := dnet.Tag4CC(binary.BigEndian.Uint32(msg.Payload))
+		if hand.removeChannel(channel) {
+			log.Printf("[%s] handler unbound from channel: [%v]", hand.name, channel)
+			events.Publish(events.HandlerUnbound, events.HandlerEvent{Name: hand.name, Channel: channel.String()})
+		}
+	case spec.TagName:
+		return hand.setName(msg.Payload)
+	case spec.TagRelay:
+		view := dnet.MsgView(msg.Payload)
+		if !view.Valid() {
+			return fmt.Errorf("invalid message or signature in relay request")
+		}
+		cha, tag := view.ChanTag()
+		err := hand.checkChannel(cha, tag)
+		if err != nil {
+			return fmt.Errorf("cannot relay: %v", err)
+		}
+		// relay to all peers except the one we received it from (once only)
+		from, ok := hand.ns.seen.Relay(view.Signature()[:], false)
+		if !ok {
+			log.Printf("[%s] not relaying message: already relayed or not recently seen: [%v][%v]", hand.name, cha, tag)
+			return nil
+		}
+		log.Printf("[%s] relaying message: [%v][%v]", hand.name, cha, tag)
+		hand.ns.forwardToPeers(dnet.RawMessage{Header: view.Header(), Payload: view.Payload()}, from)
+	default:
+		log.Printf("[%s] ignored unknown [%v] message: [%v]", hand.name, msg.Chan, msg.Tag)
 	}
+	return nil
 }
 
 func (hand *handlerConn) readBindMessage(reader io.Reader) (bind dnet.BindMessage, err error) {
@@ -108,20 +344,22 @@ func (hand *handlerConn) sendToHandler() {
 		select {
 		case raw := <-send:
 			// forward the raw message to the handler
+			name := hand.getName()
 			cha, tag := dnet.MsgView(raw.Header).ChanTag()
-			log.Printf("[%s] sending to handler: [%v][%v]", hand.name, cha, tag)
+			log.Printf("[%s] sending to handler: [%v][%v]", name, cha, tag)
 			_, err := conn.Write(raw.Header)
 			if err != nil {
-				log.Printf("[%s] cannot send to handler: %v", hand.name, err)
+				log.Printf("[%s] cannot send to handler: %v", name, err)
 				hand.ns.closeHandler(hand)
 				return
 			}
 			_, err = conn.Write(raw.Payload)
 			if err != nil {
-				log.Printf("[%s] cannot send to handler: %v", hand.name, err)
+				log.Printf("[%s] cannot send to handler: %v", name, err)
 				hand.ns.closeHandler(hand)
 				return
 			}
+			hand.msgsOut.Add(1)
 		case <-hand.ns.Context.Done():
 			// shutting down
 			hand.ns.closeHandler(hand)
@@ -0,0 +1,101 @@
+package netsvc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+
+	"code.dogecoin.org/dogenet/internal/spec"
+)
+
+// Key handover: after `dogenet key rotate`, the node sends the [Node][Hand]
+// message (signed by its old key) to every peer it connects to, until the
+// old node record would have expired anyway.
+
+const HandoverLongevity = -OldestAddrTime // send our handover for 30 days
+
+// loadHandover loads our own handover naming our node key, if any.
+// on 'Run' goroutine, before any peers connect.
+func (ns *NetService) loadHandover() {
+	rec, err := ns.store.GetHandover(ns.nodeKey.Pub[:])
+	if err != nil {
+		if !spec.IsNotFoundError(err) {
+			log.Printf("[%s] GetHandover: %v", ns.ServiceName, err)
+		}
+		return
+	}
+	h, err := spec.DecodeHandover(rec.Payload)
+	if err != nil || len(rec.PubKey) != 32 {
+		log.Printf("[%s] invalid stored handover: %v", ns.ServiceName, err)
+		return
+	}
+	ns.handoverTime = time.Unix(h.Time, 0)
+	if time.Since(ns.handoverTime) < HandoverLongevity {
+		ns.handover = dnet.ReEncodeMessage(node.ChannelNode, spec.TagHandover, (*[32]byte)(rec.PubKey), rec.Sig, rec.Payload)
+		log.Printf("[%s] sending key handover from old key: %v", ns.ServiceName, hex.EncodeToString(rec.PubKey))
+	}
+}
+
+// sendHandover sends our key handover (if any) to a newly connected peer.
+// runs on receiveFromPeer
+func (peer *peerConn) sendHandover() {
+	if len(peer.ns.handover.Header) == 0 || time.Since(peer.ns.handoverTime) > HandoverLongevity {
+		return
+	}
+	// non-blocking send to peer
+	select {
+	case peer.send <- peer.ns.handover:
+	default:
+		droppedMessages.Inc("peer")
+	}
+}
+
+// ingestHandover marks the old key in a [Node][Hand] message as superseded,
+// and re-broadcasts the message to other peers if it is new.
+// runs on receiveFromPeer
+func (peer *peerConn) ingestHandover(who string, msg dnet.Message) {
+	h, err := spec.DecodeHandover(msg.Payload)
+	if err == nil && bytes.Equal(h.NewKey[:], msg.PubKey) {
+		err = fmt.Errorf("new key is the same as the old key")
+	}
+	if err != nil {
+		log.Printf("[%s] invalid key handover: %v", who, err)
+		peer.misbehaving(peer.peerPub[:], ScoreBadHandover, "invalid handover")
+		return
+	}
+	oldHex := hex.EncodeToString(msg.PubKey)
+	newHex := hex.EncodeToString(h.NewKey[:])
+	if bytes.Equal(msg.PubKey, peer.nodeKey.Pub[:]) {
+		log.Printf("[%s] ignored handover of my own key to: %v", who, newHex)
+		return
+	}
+	if bytes.Equal(h.NewKey[:], peer.nodeKey.Pub[:]) {
+		// only `key rotate` can hand over to our key.
+		log.Printf("[%s] ignored handover to my own key from: %v", who, oldHex)
+		return
+	}
+	ts := time.Unix(h.Time, 0)
+	now := time.Now()
+	if ts.After(now.Add(NewestAddrTime)) {
+		peer.misbehaving(peer.peerPub[:], ScoreBadTimestamp, "timestamp out of range")
+		log.Printf("[%s] key handover timestamp in the future: %v [%v]", who, ts, oldHex)
+		return
+	}
+	if ts.Before(now.Add(OldestAddrTime)) {
+		return // old key has expired anyway.
+	}
+	isnew, err := peer.store.SupersedeNode(msg.PubKey, h.NewKey[:], h.Time, msg.Payload, msg.Signature, false)
+	if err != nil {
+		log.Printf("[%s] SupersedeNode: %v", who, err)
+		return
+	}
+	if isnew {
+		log.Printf("[%s] key handover: %v superseded by %v", who, oldHex, newHex)
+		peer.ns.forwardToPeers(dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}, peer)
+	}
+}
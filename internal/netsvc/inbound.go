@@ -0,0 +1,97 @@
+package netsvc
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// admitInbound decides whether to accept a new inbound peer connection.
+// If MaxInbound is reached, it chooses an existing inbound peer to evict,
+// or returns an error if the new connection should be rejected.
+// called from acceptIncoming
+func (ns *NetService) admitInbound(ip net.IP) (evict *peerConn, err error) {
+	ns.mutex.Lock() // vs trackPeer,closePeer
+	defer ns.mutex.Unlock()
+	limits := ns.limits
+	subnet := subnetKey(ip)
+	inbound := ns.inboundPeers()
+	perIP, perSubnet := 0, 0
+	for _, p := range inbound {
+		if p.remoteIP.Equal(ip) {
+			perIP++
+		}
+		if subnetKey(p.remoteIP) == subnet {
+			perSubnet++
+		}
+	}
+	if perIP >= limits.MaxPerIP {
+		return nil, fmt.Errorf("too many connections from IP address (%v)", perIP)
+	}
+	if perSubnet >= limits.MaxPerSubnet {
+		return nil, fmt.Errorf("too many connections from subnet (%v)", perSubnet)
+	}
+	if len(inbound) < limits.MaxInbound {
+		return nil, nil
+	}
+	evict = ns.chooseEviction(inbound)
+	if evict == nil {
+		return nil, fmt.Errorf("too many inbound connections (%v)", len(inbound))
+	}
+	return evict, nil
+}
+
+// chooseEviction chooses an inbound peer to disconnect, to make room for
+// a new inbound peer. Long-lived peers (the older half) are protected;
+// among the rest, prefer to evict peers that have misbehaved, then peers
+// from the most-connected subnet, then the most recently connected.
+// caller holds ns.mutex
+func (ns *NetService) chooseEviction(inbound []*peerConn) *peerConn {
+	if len(inbound) < 2 {
+		return nil
+	}
+	// protect the longest-connected half of inbound peers.
+	byAge := append([]*peerConn{}, inbound...)
+	sort.Slice(byAge, func(i, j int) bool {
+		return byAge[i].connectedAt.Before(byAge[j].connectedAt)
+	})
+	candidates := byAge[len(byAge)/2:]
+	subnets := make(map[string]int)
+	for _, p := range inbound {
+		subnets[subnetKey(p.remoteIP)]++
+	}
+	var worst *peerConn
+	worstScore, worstGroup := 0, 0
+	for _, p := range candidates {
+		score := ns.scores.get(p.remoteIP.String())
+		group := subnets[subnetKey(p.remoteIP)]
+		if worst == nil || score > worstScore ||
+			(score == worstScore && group > worstGroup) ||
+			(score == worstScore && group == worstGroup && p.connectedAt.After(worst.connectedAt)) {
+			worst, worstScore, worstGroup = p, score, group
+		}
+	}
+	return worst
+}
+
+// inboundPeers returns all inbound peer connections (including handshaking)
+// caller holds ns.mutex
+func (ns *NetService) inboundPeers() (inbound []*peerConn) {
+	for _, p := range ns.peers {
+		if !p.isOutbound {
+			inbound = append(inbound, p)
+		}
+	}
+	return
+}
+
+// subnetKey returns the subnet of an IP address (IPv4 /16 or IPv6 /32)
+func subnetKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return ip16.Mask(net.CIDRMask(32, 128)).String()
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package netsvc
+
+import (
+	"log"
+	"time"
+)
+
+// Limits are the configurable thresholds for peer connections.
+type Limits struct {
+	BanScore         int           // misbehaviour score at which a peer pubkey or IP is banned
+	BanDuration      time.Duration // how long a ban lasts
+	MaxInbound       int           // maximum number of inbound peer connections
+	MaxPerIP         int           // maximum inbound connections from one IP address
+	MaxPerSubnet     int           // maximum inbound connections from one subnet (IPv4 /16 or IPv6 /32)
+	HandshakeTimeout time.Duration // time allowed to exchange [Node][Addr] messages
+}
+
+var DefaultLimits = Limits{
+	BanScore:         100,
+	BanDuration:      24 * time.Hour,
+	MaxInbound:       64,
+	MaxPerIP:         4,
+	MaxPerSubnet:     8,
+	HandshakeTimeout: 30 * time.Second,
+}
+
+// called from any
+func (ns *NetService) getLimits() Limits {
+	ns.mutex.Lock() // vs setLimits
+	defer ns.mutex.Unlock()
+	return ns.limits
+}
+
+// SetLimits changes connection limits and ban thresholds.
+// New limits apply to new connections and offences; existing
+// connections are not closed if they exceed the new limits.
+// called from any
+func (ns *NetService) SetLimits(limits Limits) {
+	ns.mutex.Lock() // vs getLimits,admitInbound
+	defer ns.mutex.Unlock()
+	if limits != ns.limits {
+		log.Printf("[%s] new limits: %+v", ns.ServiceName, limits)
+		ns.limits = limits
+	}
+}
@@ -0,0 +1,34 @@
+package netsvc
+
+import (
+	"code.dogecoin.org/dogenet/internal/metrics"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var (
+	messagesReceived  = metrics.NewCounterVec("dogenet_peer_messages_received_total", "Messages received from peers.", "channel", "tag")
+	messagesSent      = metrics.NewCounterVec("dogenet_peer_messages_sent_total", "Messages sent to peers.", "channel", "tag")
+	bytesReceived     = metrics.NewCounter("dogenet_peer_bytes_received_total", "Bytes received from peers.")
+	bytesSent         = metrics.NewCounter("dogenet_peer_bytes_sent_total", "Bytes sent to peers.")
+	handshakeFailures = metrics.NewCounterVec("dogenet_handshake_failures_total", "Peer connections closed during the [Node][Addr] exchange.", "reason")
+	droppedMessages   = metrics.NewCounterVec("dogenet_dropped_messages_total", "Messages dropped because a send queue was full.", "queue")
+)
+
+// countReceived updates the global metrics and the peer's own counters.
+func (peer *peerConn) countReceived(msg dnet.Message) {
+	size := uint64(dnet.HeaderSize + len(msg.Payload))
+	messagesReceived.Inc(msg.Chan.String(), msg.Tag.String())
+	bytesReceived.Add(size)
+	peer.msgsIn.Add(1)
+	peer.bytesIn.Add(size)
+}
+
+// countSent updates the global metrics and the peer's own counters.
+func (peer *peerConn) countSent(raw dnet.RawMessage) {
+	size := uint64(len(raw.Header) + len(raw.Payload))
+	cha, tag := dnet.MsgView(raw.Header).ChanTag()
+	messagesSent.Inc(cha.String(), tag.String())
+	bytesSent.Add(size)
+	peer.msgsOut.Add(1)
+	peer.bytesOut.Add(size)
+}
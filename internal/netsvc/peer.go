@@ -7,13 +7,16 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.dogecoin.org/gossip/dnet"
 	"code.dogecoin.org/gossip/node"
 
+	"code.dogecoin.org/dogenet/internal/events"
 	"code.dogecoin.org/dogenet/internal/spec"
 )
 
@@ -26,33 +29,52 @@ const WaitForAnnounceTime = 1 * time.Second   // fast poll (hack)
 // and periodically send gossip from channel owners.
 
 type peerConn struct {
-	ns         *NetService
-	conn       net.Conn
-	store      spec.Store
-	allowLocal bool
-	isOutbound bool
-	hasPub     bool // has a peer pubkey
-	receive    map[dnet.Tag4CC]chan dnet.Message
-	send       chan dnet.RawMessage // raw message
-	mutex      sync.Mutex
-	addr       spec.Address // Peer's public address
-	peerPub    [32]byte     // Peer's pubkey (pre-set for outbound, if known)
-	nodeKey    dnet.KeyPair // [const] to sign `Addr` messages (key for THIS node)
+	ns          *NetService
+	conn        net.Conn
+	store       spec.Store
+	allowLocal  bool
+	isOutbound  bool
+	hasPub      bool // has a peer pubkey
+	receive     map[dnet.Tag4CC]chan dnet.Message
+	send        chan dnet.RawMessage // raw message
+	mutex       sync.Mutex
+	addr        spec.Address  // Peer's public address
+	remoteIP    net.IP        // [const] IP address of the connection (for bans)
+	peerPub     [32]byte      // Peer's pubkey (pre-set for outbound, if known)
+	channels    []dnet.Tag4CC // Peer's announced channels (mutex)
+	nodeKey     dnet.KeyPair  // [const] to sign `Addr` messages (key for THIS node)
+	quit        chan struct{} // closed by closePeer
+	connectedAt time.Time     // [const] time the connection was established
+	ready       atomic.Bool   // handshake ([Node][Addr] exchange) has completed
+	quitOnce    sync.Once
+	msgsIn      atomic.Uint64 // messages received from the peer
+	msgsOut     atomic.Uint64 // messages sent to the peer
+	bytesIn     atomic.Uint64 // bytes received from the peer
+	bytesOut    atomic.Uint64 // bytes sent to the peer
+	// keepalive state (mutex)
+	pingNonce   uint64
+	pingSent    time.Time     // zero if no ping outstanding
+	lastMsgsIn  uint64        // msgsIn at the last ping interval
+	silentTicks int           // consecutive ping intervals with no messages received
+	rtt         time.Duration // last measured round-trip time
 }
 
 func newPeer(conn net.Conn, addr spec.Address, peerPub [32]byte, outbound bool, hasPub bool, ns *NetService) *peerConn {
 	peer := &peerConn{
-		ns:         ns,
-		conn:       conn,
-		store:      ns.store,
-		allowLocal: ns.allowLocal, // allow local IP address in Announcement messages (for local testing)
-		isOutbound: outbound,
-		hasPub:     hasPub,
-		receive:    make(map[dnet.Tag4CC]chan dnet.Message),
-		send:       make(chan dnet.RawMessage, 100),
-		addr:       addr,
-		peerPub:    peerPub,
-		nodeKey:    ns.nodeKey,
+		ns:          ns,
+		conn:        conn,
+		store:       ns.store,
+		allowLocal:  ns.allowLocal, // allow local IP address in Announcement messages (for local testing)
+		isOutbound:  outbound,
+		hasPub:      hasPub,
+		receive:     make(map[dnet.Tag4CC]chan dnet.Message),
+		send:        make(chan dnet.RawMessage, 100),
+		addr:        addr,
+		remoteIP:    remoteIP(conn),
+		peerPub:     peerPub,
+		nodeKey:     ns.nodeKey,
+		quit:        make(chan struct{}),
+		connectedAt: time.Now(),
 	}
 	return peer
 }
@@ -71,12 +93,15 @@ func (peer *peerConn) start() {
 func (peer *peerConn) receiveFromPeer(who string) {
 	conn := peer.conn
 	reader := bufio.NewReader(conn)
+	// the peer must complete the [Node][Addr] exchange within the timeout.
+	conn.SetReadDeadline(time.Now().Add(peer.ns.getLimits().HandshakeTimeout))
 	if peer.isOutbound {
 		// An outbound connection; we send the initial announce message.
 		// 1. MUST announce THIS node's [Node][Addr] on outbound connections.
 		err := peer.sendMyAddress(conn, who)
 		if err != nil {
 			log.Printf("[%s] failed to send [Node][Addr] to peer: %v", who, err)
+			handshakeFailures.Inc("send-addr")
 			peer.ns.closePeer(peer)
 			return
 		}
@@ -85,13 +110,17 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		msg, err := dnet.ReadMessage(reader)
 		if err != nil {
 			log.Printf("[%s] failed to receive return announcement: %v", who, err)
+			handshakeFailures.Inc("receive-addr")
 			peer.ns.closePeer(peer)
 			return
 		}
 		log.Printf("[%s] received first reply (outbound)", who)
+		peer.countReceived(msg)
 		// 3. MUST be a [Node][Addr] message announcing the peer.
 		if msg.Chan != node.ChannelNode || msg.Tag != node.TagAddress {
 			log.Printf("[%s] expecting [Node][Addr] message but received: [%v][%v]", who, msg.Chan.String(), msg.Tag.String())
+			peer.misbehaving(msg.PubKey, ScoreBadFirstMsg, "wrong first message")
+			handshakeFailures.Inc("wrong-first-message")
 			peer.ns.closePeer(peer)
 			return
 		}
@@ -100,16 +129,26 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		if peer.hasPub {
 			if !bytes.Equal(msg.PubKey, peer.peerPub[:]) {
 				log.Printf("[%s] connected to wrong peer: found PubKey %v but expected %v", who, hex.EncodeToString(msg.PubKey), hex.EncodeToString(peer.peerPub[:]))
+				// not misbehaviour: the address may have been reassigned, or the
+				// node has rotated its key; closePeer records a failed attempt.
+				handshakeFailures.Inc("wrong-peer")
 				peer.ns.closePeer(peer)
 				return
 			}
 		} else {
 			copy(peer.peerPub[:], msg.PubKey)
 			who = fmt.Sprintf("%v/%v", hex.EncodeToString(peer.peerPub[0:6]), peer.addr.String())
+			if peer.ns.isBanned(msg.PubKey, nil) {
+				log.Printf("[%s] peer is banned: [%v] (outbound connection)", who, hex.EncodeToString(msg.PubKey))
+				handshakeFailures.Inc("banned")
+				peer.ns.closePeer(peer)
+				return
+			}
 			// Check if we're already connected to this peer
 			// Only call this if we started with NoPubKey (hasPub == false)
 			if !peer.ns.adoptPeer(peer, peer.peerPub) {
 				log.Printf("[%s] already connected to peer: [%v] (inbound connection)", who, hex.EncodeToString(msg.PubKey))
+				handshakeFailures.Inc("duplicate")
 				peer.ns.closePeer(peer)
 				return
 			}
@@ -117,6 +156,7 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		// 5. Check if we received our own pubkey (connected to self)
 		if bytes.Equal(msg.PubKey, peer.nodeKey.Pub[:]) {
 			log.Printf("[%s] connected to self: [%v] (outbound connection)", who, hex.EncodeToString(msg.PubKey))
+			handshakeFailures.Inc("self")
 			peer.ns.closePeer(peer)
 			return
 		}
@@ -125,6 +165,7 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		newwho, err := peer.ingestAddress(msg)
 		if err != nil {
 			log.Printf("[%s] %v", who, err)
+			handshakeFailures.Inc("bad-address")
 			peer.ns.closePeer(peer)
 			return
 		} else {
@@ -132,33 +173,46 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		}
 		// 7. OK to start forwaring messages to the peer now.
 		go peer.sendToPeer(who)
+		go peer.pingPeer(who)
 	} else {
 		// MUST be an inbound connection.
 		// 1. Wait for the [Node][Addr] announcement from the peer.
 		msg, err := dnet.ReadMessage(reader)
 		if err != nil {
 			log.Printf("[%s] failed to receive first inbound message: %v", who, err)
+			handshakeFailures.Inc("receive-addr")
 			peer.ns.closePeer(peer)
 			return
 		}
 		log.Printf("[%s] received first message (inbound): %v", who, msg.Tag)
+		peer.countReceived(msg)
 		copy(peer.peerPub[:], msg.PubKey)
 		who = fmt.Sprintf("%v/%v", hex.EncodeToString(peer.peerPub[0:6]), peer.addr.String())
+		if peer.ns.isBanned(msg.PubKey, nil) {
+			log.Printf("[%s] peer is banned: [%v] (inbound connection)", who, hex.EncodeToString(msg.PubKey))
+			handshakeFailures.Inc("banned")
+			peer.ns.closePeer(peer)
+			return
+		}
 		// 2. Check if we received our own pubkey (connected to self)
 		if bytes.Equal(msg.PubKey, peer.nodeKey.Pub[:]) {
 			log.Printf("[%s] connected to self: [%v] (inbound connection)", who, hex.EncodeToString(msg.PubKey))
+			handshakeFailures.Inc("self")
 			peer.ns.closePeer(peer)
 			return
 		}
 		// 3. Check if we're already connected to this peer
 		if !peer.ns.adoptPeer(peer, peer.peerPub) {
 			log.Printf("[%s] already connected to peer: [%v] (inbound connection)", who, hex.EncodeToString(msg.PubKey))
+			handshakeFailures.Inc("duplicate")
 			peer.ns.closePeer(peer)
 			return
 		}
 		// 4. Verify it is a [Node][Addr] message.
 		if msg.Chan != node.ChannelNode || msg.Tag != node.TagAddress {
 			log.Printf("[%s] expecting [Node][Addr] message but received: [%v][%v] (inbound connection)", who, msg.Chan.String(), msg.Tag.String())
+			peer.misbehaving(msg.PubKey, ScoreBadFirstMsg, "wrong first message")
+			handshakeFailures.Inc("wrong-first-message")
 			peer.ns.closePeer(peer)
 			return
 		}
@@ -167,6 +221,7 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		who, err := peer.ingestAddress(msg)
 		if err != nil {
 			log.Printf("[%s] %v", who, err)
+			handshakeFailures.Inc("bad-address")
 			peer.ns.closePeer(peer)
 			return
 		}
@@ -174,26 +229,37 @@ func (peer *peerConn) receiveFromPeer(who string) {
 		err = peer.sendMyAddress(conn, who)
 		if err != nil {
 			log.Printf("[%s] failed to send [Node][Addr] to peer: %v", who, err)
+			handshakeFailures.Inc("send-addr")
 			peer.ns.closePeer(peer)
 			return
 		}
 		log.Printf("[%s] sent first reply (outbound): %v", who, msg.Tag)
 		// 7. OK to start forwaring messages to the peer now.
 		go peer.sendToPeer(who)
+		go peer.pingPeer(who)
 	}
 	// Once peers have exchanged [Node][Addr] messages,
 	// start relaying inbound messages to the protocol handlers.
+	conn.SetReadDeadline(time.Time{}) // handshake complete: pings detect dead peers
+	peer.ready.Store(true)
+	if peer.isOutbound {
+		peer.ns.recordAttempt(peer.peerPub, true)
+	}
+	events.Publish(events.PeerConnected, peer.event())
+	peer.sendHandover()
 	for !peer.ns.Stopping() {
 		msg, err := dnet.ReadMessage(reader)
 		if err != nil {
 			if strings.Contains(err.Error(), "signature") {
 				log.Printf("[%s] failed to receive from peer: badness: %v", who, err)
+				peer.misbehaving(peer.peerPub[:], ScoreBadSignature, "invalid signature")
 			}
 			log.Printf("[%s] failed to receive from peer: %v", who, err)
 			peer.ns.closePeer(peer)
 			return
 		}
 		log.Printf("[%s] received from peer: [%v][%v]", who, msg.Chan, msg.Tag)
+		peer.countReceived(msg)
 		if msg.Chan == node.ChannelNode {
 			if msg.Tag == node.TagAddress {
 				// Received a [Node][Addr] announcement about some/any node.
@@ -202,18 +268,33 @@ func (peer *peerConn) receiveFromPeer(who string) {
 				if bytes.Equal(msg.PubKey, peer.nodeKey.Pub[:]) {
 					log.Printf("[%s] ignored my own announce: [%v]", who, hex.EncodeToString(msg.PubKey))
 				} else {
-					who, err = peer.ingestAddress(msg)
+					newwho, err := peer.ingestAddress(msg)
 					if err != nil {
 						log.Printf("[%s] %v", who, err)
 						peer.ns.closePeer(peer)
 						return
 					}
+					if newwho != "" { // empty if the record was dropped
+						who = newwho
+					}
 				}
+			} else if msg.Tag == spec.TagHandover {
+				peer.ingestHandover(who, msg)
+			} else if msg.Tag == TagPing {
+				peer.receivePing(who, msg)
+			} else if msg.Tag == TagPong {
+				peer.receivePong(who, msg)
 			} else {
 				log.Printf("[%s] ignored unknown [Node] message: [%v]", who, msg.Tag)
 			}
 		} else {
+			// Drop messages we have already received (from any peer)
+			if peer.ns.seen.Check(msg.Signature, peer) {
+				log.Printf("[%s] dropped duplicate message: [%v][%v]", who, msg.Chan, msg.Tag)
+				continue
+			}
 			// Forward the received message to channel owners.
+			// Handlers validate the message, and relay it with [DNet][Rlay].
 			if !peer.ns.forwardToHandlers(msg.Chan, msg.RawHdr, msg.Payload) {
 				log.Printf("[%s] no handlers on channel: %s", who, msg.Chan)
 			}
@@ -221,11 +302,21 @@ func (peer *peerConn) receiveFromPeer(who string) {
 	}
 }
 
+// ingestAddress validates and stores a [Node][Addr] message. Only the peer's
+// own announcement counts towards its misbehaviour score and returns an error;
+// invalid records about other nodes (relayed by the peer) are dropped, since
+// honest peers re-gossip stored records that may be stale or private.
 // runs in receiveFromPeer
 func (peer *peerConn) ingestAddress(msg dnet.Message) (who string, err error) {
+	self := bytes.Equal(msg.PubKey, peer.peerPub[:])
 	defer func() {
 		if e := recover(); e != nil { // for DecodeAddrMsg
-			err = fmt.Errorf("address decode error: %v", e)
+			if self {
+				err = fmt.Errorf("address decode error: %v", e)
+				peer.misbehaving(peer.peerPub[:], ScoreBadAddress, "address decode error")
+			} else {
+				log.Printf("dropped relayed address: decode error: %v [%v]", e, hex.EncodeToString(msg.PubKey))
+			}
 		}
 	}()
 	// Check that the peer address is a public IP address
@@ -237,37 +328,118 @@ func (peer *peerConn) ingestAddress(msg dnet.Message) (who string, err error) {
 	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
 		if peer.allowLocal {
 			log.Printf("peer announced a private address: %v [%v] (allowed via --local=true)", peerAddr, hexpub)
-		} else {
+		} else if self {
+			peer.misbehaving(peer.peerPub[:], ScorePrivateAddr, "private address")
 			return "", fmt.Errorf("peer announced a private address: %v [%v]", peerAddr, hexpub)
+		} else {
+			log.Printf("dropped relayed address: private address: %v [%v]", peerAddr, hexpub)
+			return
 		}
 	}
 	// Check the timestamp: cannot be older than the expiry time, or too far into the future.
 	ts := addr.Time.Local()
 	now := time.Now()
 	if ts.Before(now.Add(OldestAddrTime)) || ts.After(now.Add(NewestAddrTime)) {
+		if !self {
+			log.Printf("dropped relayed address: timestamp out of range: %v: %v [%v]", ts.String(), peerAddr, hexpub)
+			return
+		}
+		peer.misbehaving(peer.peerPub[:], ScoreBadTimestamp, "timestamp out of range")
 		return "", fmt.Errorf("peer timestamp out of range: %v vs %v (our time): %v [%v]", ts.String(), now.String(), peerAddr, hexpub)
 	}
 	// Update peer address and `who` string.
 	who = fmt.Sprintf("%v/%v", hex.EncodeToString(msg.PubKey[0:6]), peerAddr)
 	peer.setPeerAddress(peerAddr)
+	if self {
+		// the peer announced itself: remember its channels.
+		peer.setPeerChannels(addr.Channels)
+	}
 	// Add the peer to our database (update peer info for known peer)
-	isnew, err := peer.store.AddNetNode(msg.PubKey, peerAddr, ts.Unix(), addr.Owner, addr.Channels, msg.Payload, msg.Signature)
+	isnew, err := peer.store.AddNetNode(msg.PubKey, peerAddr, ts.Unix(), addr.Owner, addr.Channels, addr.Services, msg.Payload, msg.Signature)
 	if isnew {
 		log.Printf("[%s] added node: %v %v", who, peerAddr, hexpub)
-		// re-broadcast the `Addr` message to all connected peers
-		peer.ns.forwardToPeers(dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload})
+		// re-broadcast the `Addr` message to all other connected peers
+		peer.ns.forwardToPeers(dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}, peer)
 	} else {
 		log.Printf("[%s] already known: %v %v", who, peerAddr, hexpub)
 	}
 	return
 }
 
+// misbehaving adds to the misbehaviour score of the peer pubkey and IP address.
+// runs on receiveFromPeer
+func (peer *peerConn) misbehaving(pubKey []byte, points int, reason string) {
+	peer.ns.misbehaving(pubKey, peer.remoteIP, points, reason)
+}
+
+// remoteIP returns the IP address of the remote end of a TCP connection.
+func remoteIP(conn net.Conn) net.IP {
+	if tcp, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	return nil
+}
+
 func (peer *peerConn) setPeerAddress(peerAddr dnet.Address) {
 	peer.mutex.Lock()
 	defer peer.mutex.Unlock()
 	peer.addr = peerAddr
 }
 
+func (peer *peerConn) setPeerChannels(channels []dnet.Tag4CC) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.channels = channels
+}
+
+// hasChannel returns true if the peer announced the channel.
+// called from any
+func (peer *peerConn) hasChannel(channel dnet.Tag4CC) bool {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	return slices.Contains(peer.channels, channel)
+}
+
+// info returns a snapshot of the connection state.
+// `hasKey` is true if peerPub is final (the peer is in connectedPeers)
+// called from any (with ns.mutex held)
+func (peer *peerConn) info(hasKey bool) spec.PeerInfo {
+	info := spec.PeerInfo{
+		Direction: "inbound",
+		Connected: peer.connectedAt.Unix(),
+		Handshake: peer.ready.Load(),
+		MsgsIn:    peer.msgsIn.Load(),
+		MsgsOut:   peer.msgsOut.Load(),
+		BytesIn:   peer.bytesIn.Load(),
+		BytesOut:  peer.bytesOut.Load(),
+		SendQueue: len(peer.send),
+	}
+	if peer.isOutbound {
+		info.Direction = "outbound"
+	}
+	if hasKey {
+		info.PubKey = hex.EncodeToString(peer.peerPub[:])
+	}
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	info.Address = peer.addr.String()
+	info.RTT = peer.rtt.Milliseconds()
+	return info
+}
+
+// event describes the peer for the event bus (once peerPub is final)
+// called from any
+func (peer *peerConn) event() events.PeerEvent {
+	ev := events.PeerEvent{PubKey: hex.EncodeToString(peer.peerPub[:]), Direction: "inbound"}
+	if peer.isOutbound {
+		ev.Direction = "outbound"
+	}
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	ev.Address = peer.addr.String()
+	return ev
+}
+
 // goroutine
 func (peer *peerConn) sendToPeer(who string) {
 	conn := peer.conn
@@ -289,6 +461,10 @@ func (peer *peerConn) sendToPeer(who string) {
 				peer.ns.closePeer(peer)
 				return
 			}
+			peer.countSent(raw)
+		case <-peer.quit:
+			// peer connection closed
+			return
 		case <-peer.ns.Context.Done():
 			// shutting down
 			// no race: peer.peerPub is final before sendToPeer starts (closePeer OK to read peer.peerPub)
@@ -316,5 +492,9 @@ func (peer *peerConn) sendMyAddress(conn net.Conn, who string) error {
 		return err
 	}
 	_, err = conn.Write(msg.Payload)
-	return err
+	if err != nil {
+		return err
+	}
+	peer.countSent(msg)
+	return nil
 }
@@ -0,0 +1,118 @@
+package netsvc
+
+import (
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Protocol-level keepalive: [Node][Ping] and [Node][Pong] messages.
+// The payload is an 8-byte nonce, which the Pong echoes back.
+
+var TagPing = dnet.NewTag("Ping")
+var TagPong = dnet.NewTag("Pong")
+
+const PingInterval = 60 * time.Second // time between pings to each peer
+const MaxSilentIntervals = 3          // disconnect after this many ping intervals without any message
+const PingNonceSize = 8
+
+// pingPeer sends a Ping every PingInterval, and disconnects the peer once
+// nothing at all has been received from it for MaxSilentIntervals.
+// Any message counts as proof the peer is alive, not just a Pong, because
+// peers on older releases ignore [Node][Ping] and never reply.
+// goroutine
+func (peer *peerConn) pingPeer(who string) {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	for !peer.ns.Stopping() {
+		select {
+		case <-ticker.C:
+			silent := peer.checkAlive()
+			if silent >= MaxSilentIntervals {
+				log.Printf("[%s] peer silent for %v ping intervals: disconnecting", who, silent)
+				peer.ns.closePeer(peer)
+				return
+			}
+			nonce := rand.Uint64()
+			payload := binary.LittleEndian.AppendUint64(nil, nonce)
+			peer.setPing(nonce)
+			// non-blocking send to peer
+			select {
+			case peer.send <- dnet.EncodeMessageRaw(node.ChannelNode, TagPing, peer.nodeKey, payload):
+			default:
+				droppedMessages.Inc("peer")
+			}
+		case <-peer.quit:
+			return
+		case <-peer.ns.Context.Done():
+			return
+		}
+	}
+}
+
+// receivePing replies to a [Node][Ping] with a [Node][Pong] echoing the nonce.
+// runs on receiveFromPeer
+func (peer *peerConn) receivePing(who string, msg dnet.Message) {
+	if len(msg.Payload) != PingNonceSize {
+		log.Printf("[%s] invalid ping (wrong size)", who)
+		return
+	}
+	// non-blocking send to peer
+	select {
+	case peer.send <- dnet.EncodeMessageRaw(node.ChannelNode, TagPong, peer.nodeKey, msg.Payload):
+	default:
+		droppedMessages.Inc("peer")
+	}
+}
+
+// receivePong records the round-trip time if the Pong matches our last Ping.
+// runs on receiveFromPeer
+func (peer *peerConn) receivePong(who string, msg dnet.Message) {
+	if len(msg.Payload) != PingNonceSize {
+		log.Printf("[%s] invalid pong (wrong size)", who)
+		return
+	}
+	nonce := binary.LittleEndian.Uint64(msg.Payload)
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if peer.pingSent.IsZero() || nonce != peer.pingNonce {
+		return // stale or unsolicited pong
+	}
+	peer.rtt = time.Since(peer.pingSent)
+	peer.pingSent = time.Time{}
+}
+
+// checkAlive counts a silent interval if no message has been received
+// from the peer since the last call; returns the number of consecutive
+// silent intervals.
+func (peer *peerConn) checkAlive() int {
+	received := peer.msgsIn.Load()
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	if received != peer.lastMsgsIn {
+		peer.lastMsgsIn = received
+		peer.silentTicks = 0
+	} else {
+		peer.silentTicks++
+	}
+	return peer.silentTicks
+}
+
+func (peer *peerConn) setPing(nonce uint64) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	peer.pingNonce = nonce
+	peer.pingSent = time.Now()
+}
+
+// getRTT returns the last measured round-trip time (zero if not yet measured)
+// called from any
+func (peer *peerConn) getRTT() time.Duration {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+	return peer.rtt
+}
@@ -0,0 +1,44 @@
+package netsvc
+
+import (
+	"testing"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// A peer on an older release never replies to [Node][Ping],
+// but it stays connected as long as it keeps sending other messages.
+func TestPingPeerNeverReplies(t *testing.T) {
+	peer := &peerConn{}
+	addr := dnet.Message{Chan: node.ChannelNode, Tag: node.TagAddress, Payload: make([]byte, 64)}
+	for i := 0; i < 2*MaxSilentIntervals; i++ {
+		peer.setPing(uint64(i)) // never answered
+		peer.countReceived(addr)
+		if silent := peer.checkAlive(); silent != 0 {
+			t.Fatalf("interval %v: expecting 0 silent intervals, got %v", i, silent)
+		}
+	}
+	if peer.getRTT() != 0 {
+		t.Errorf("expecting no RTT without a pong, got %v", peer.getRTT())
+	}
+}
+
+// A peer that sends nothing at all is disconnected after MaxSilentIntervals.
+func TestPingPeerSilent(t *testing.T) {
+	peer := &peerConn{}
+	for i := 1; i < MaxSilentIntervals; i++ {
+		peer.setPing(uint64(i))
+		if silent := peer.checkAlive(); silent != i {
+			t.Fatalf("expecting %v silent intervals, got %v", i, silent)
+		}
+	}
+	if silent := peer.checkAlive(); silent < MaxSilentIntervals {
+		t.Fatalf("expecting disconnect after %v silent intervals, got %v", MaxSilentIntervals, silent)
+	}
+	// any message resets the count
+	peer.countReceived(dnet.Message{Chan: node.ChannelNode, Tag: TagPong, Payload: make([]byte, PingNonceSize)})
+	if silent := peer.checkAlive(); silent != 0 {
+		t.Errorf("expecting 0 silent intervals after a message, got %v", silent)
+	}
+}
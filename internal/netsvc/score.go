@@ -0,0 +1,139 @@
+package netsvc
+
+import (
+	"encoding/hex"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Misbehaviour scores for peer offences.
+// A peer is banned once its score reaches Limits.BanScore.
+const (
+	ScoreBadSignature  = 100            // message with an invalid signature
+	ScoreBadFirstMsg   = 50             // first message is not [Node][Addr]
+	ScoreBadAddress    = 50             // undecodable announcement
+	ScoreBadHandover   = 50             // undecodable key handover
+	ScorePrivateAddr   = 50             // announced a private address
+	ScoreBadTimestamp  = 20             // announcement timestamp out of range
+	ScoreWindow        = 24 * time.Hour // scores are forgotten after this long without offences
+	scorePruneInterval = 1000           // prune expired scores every N offences
+)
+
+// scoreBoard tracks misbehaviour scores by peer pubkey and by IP address.
+type scoreBoard struct {
+	mutex  sync.Mutex
+	scores map[string]*score // hex pubkey or IP string -> score (mutex)
+	count  int               // offences since last prune (mutex)
+}
+
+type score struct {
+	points int
+	last   time.Time
+}
+
+func newScoreBoard() *scoreBoard {
+	return &scoreBoard{scores: make(map[string]*score)}
+}
+
+// add adds points to the score for `key` and returns the new score.
+func (b *scoreBoard) add(key string, points int) int {
+	now := time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.count++
+	if b.count >= scorePruneInterval {
+		b.prune(now)
+	}
+	sc, have := b.scores[key]
+	if !have || now.Sub(sc.last) > ScoreWindow {
+		sc = &score{}
+		b.scores[key] = sc
+	}
+	sc.points += points
+	sc.last = now
+	return sc.points
+}
+
+// get returns the current score for `key`
+func (b *scoreBoard) get(key string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if sc, have := b.scores[key]; have && time.Since(sc.last) <= ScoreWindow {
+		return sc.points
+	}
+	return 0
+}
+
+// reset forgets the score for `key` (e.g. once banned)
+func (b *scoreBoard) reset(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.scores, key)
+}
+
+func (b *scoreBoard) prune(now time.Time) {
+	for key, sc := range b.scores {
+		if now.Sub(sc.last) > ScoreWindow {
+			delete(b.scores, key)
+		}
+	}
+	b.count = 0
+}
+
+// misbehaving adds to the misbehaviour score of a peer pubkey (if known)
+// and its IP address, and bans them once the score reaches Limits.BanScore.
+// called from any peer
+func (ns *NetService) misbehaving(pubKey []byte, ip net.IP, points int, reason string) {
+	limits := ns.getLimits()
+	until := time.Now().Add(limits.BanDuration).Unix()
+	if pubKey != nil && !isNoPubKey(pubKey) {
+		key := hex.EncodeToString(pubKey)
+		if ns.scores.add(key, points) >= limits.BanScore {
+			ns.scores.reset(key)
+			log.Printf("[%s] banning peer %v until %v: %v", ns.ServiceName, key, time.Unix(until, 0), reason)
+			err := ns.store.AddBan(pubKey, until, reason)
+			if err != nil {
+				log.Printf("[%s] AddBan: %v", ns.ServiceName, err)
+			}
+		}
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		key := ip.String()
+		if ns.scores.add(key, points) >= limits.BanScore {
+			ns.scores.reset(key)
+			log.Printf("[%s] banning IP %v until %v: %v", ns.ServiceName, key, time.Unix(until, 0), reason)
+			err := ns.store.AddBan(ip16, until, reason)
+			if err != nil {
+				log.Printf("[%s] AddBan: %v", ns.ServiceName, err)
+			}
+		}
+	}
+}
+
+// isBanned returns true if the pubkey (if not nil) or IP address is banned.
+// called from any
+func (ns *NetService) isBanned(pubKey []byte, ip net.IP) bool {
+	if pubKey != nil && !isNoPubKey(pubKey) {
+		banned, err := ns.store.IsBanned(pubKey)
+		if err != nil {
+			log.Printf("[%s] IsBanned: %v", ns.ServiceName, err)
+		} else if banned {
+			return true
+		}
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		banned, err := ns.store.IsBanned(ip16)
+		if err != nil {
+			log.Printf("[%s] IsBanned: %v", ns.ServiceName, err)
+		} else if banned {
+			return true
+		}
+	}
+	return false
+}
+
+func isNoPubKey(pubKey []byte) bool {
+	return len(pubKey) == len(NoPubKey) && [32]byte(pubKey) == NoPubKey
+}
@@ -0,0 +1,115 @@
+package netsvc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const SeenCacheSize = 10000           // maximum number of message signatures to remember
+const SeenCacheTTL = 10 * time.Minute // how long to remember each message signature
+
+// seenCache remembers the signatures of recently seen messages,
+// so the same gossip message is only delivered once.
+// Entries expire after `ttl`; the oldest entries are evicted beyond `size`.
+type seenCache struct {
+	mutex  sync.Mutex
+	size   int
+	ttl    time.Duration
+	seen   map[[64]byte]seenInfo // signature -> expiry time, origin (mutex)
+	queue  []seenEntry           // in insertion order, which is also expiry order (mutex)
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type seenInfo struct {
+	expires time.Time
+	from    *peerConn // peer we first received the message from (nil if local)
+	relayed bool      // message has been relayed to peers
+}
+
+type seenEntry struct {
+	sig     [64]byte
+	expires time.Time
+}
+
+func newSeenCache(size int, ttl time.Duration) *seenCache {
+	return &seenCache{
+		size: size,
+		ttl:  ttl,
+		seen: make(map[[64]byte]seenInfo),
+	}
+}
+
+// Check returns true if the message signature has been seen before;
+// otherwise it remembers the signature and origin peer and returns false.
+// called from any
+func (c *seenCache) Check(signature []byte, from *peerConn) bool {
+	sig := [64]byte(signature)
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if info, have := c.seen[sig]; have && now.Before(info.expires) {
+		c.hits.Add(1)
+		return true
+	}
+	c.misses.Add(1)
+	c.expire(now)
+	expires := now.Add(c.ttl)
+	c.seen[sig] = seenInfo{expires: expires, from: from}
+	c.queue = append(c.queue, seenEntry{sig: sig, expires: expires})
+	return false
+}
+
+// expire removes expired entries, and the oldest entries beyond the size limit.
+func (c *seenCache) expire(now time.Time) {
+	n := 0
+	for n < len(c.queue) && (len(c.queue)-n >= c.size || !now.Before(c.queue[n].expires)) {
+		ent := c.queue[n]
+		// only remove the map entry if it hasn't been re-added since.
+		if c.seen[ent.sig].expires == ent.expires {
+			delete(c.seen, ent.sig)
+		}
+		n++
+	}
+	if n > 0 {
+		c.queue = c.queue[n:] // append will eventually re-allocate
+	}
+}
+
+// Relay marks the message as relayed, so it is relayed to peers at most once.
+// Returns the origin peer (nil if local) and true if the message should be
+// relayed now; false if it has already been relayed, or if it has not been
+// seen recently: then the origin is unknown, and relaying it could echo it
+// back to that peer. If `local`, an unseen message is remembered as a new
+// local message instead.
+// called from any
+func (c *seenCache) Relay(signature []byte, local bool) (from *peerConn, ok bool) {
+	sig := [64]byte(signature)
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if info, have := c.seen[sig]; have && now.Before(info.expires) {
+		if info.relayed {
+			c.hits.Add(1)
+			return nil, false
+		}
+		info.relayed = true
+		c.seen[sig] = info
+		return info.from, true
+	}
+	if !local {
+		return nil, false
+	}
+	c.misses.Add(1)
+	c.expire(now)
+	expires := now.Add(c.ttl)
+	c.seen[sig] = seenInfo{expires: expires, relayed: true}
+	c.queue = append(c.queue, seenEntry{sig: sig, expires: expires})
+	return nil, true
+}
+
+// Stats returns the number of cache hits (duplicates) and misses (new messages).
+func (c *seenCache) Stats() (hits uint64, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
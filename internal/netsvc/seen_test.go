@@ -0,0 +1,52 @@
+package netsvc
+
+import (
+	"testing"
+	"time"
+)
+
+func testSig(n byte) []byte {
+	sig := make([]byte, 64)
+	sig[0] = n
+	return sig
+}
+
+func TestSeenRelayOnce(t *testing.T) {
+	c := newSeenCache(10, time.Minute)
+	peer := &peerConn{}
+	c.Check(testSig(1), peer)
+	from, ok := c.Relay(testSig(1), false)
+	if !ok || from != peer {
+		t.Errorf("expecting first relay from the origin peer, got: %v %v", from, ok)
+	}
+	if _, ok = c.Relay(testSig(1), false); ok {
+		t.Errorf("expecting a second relay to be refused")
+	}
+	if _, ok = c.Relay(testSig(1), true); ok {
+		t.Errorf("expecting a handler re-sending a relayed message to be refused")
+	}
+	// the origin of an unseen message is unknown: do not relay.
+	if _, ok = c.Relay(testSig(2), false); ok {
+		t.Errorf("expecting an unseen message not to be relayed")
+	}
+	// a new local message is relayed once, and remembered.
+	from, ok = c.Relay(testSig(3), true)
+	if !ok || from != nil {
+		t.Errorf("expecting a new local message to be relayed, got: %v %v", from, ok)
+	}
+	if _, ok = c.Relay(testSig(3), true); ok {
+		t.Errorf("expecting a repeated local message to be refused")
+	}
+	if !c.Check(testSig(3), peer) {
+		t.Errorf("expecting a local message to be remembered as seen")
+	}
+}
+
+func TestSeenRelayExpired(t *testing.T) {
+	c := newSeenCache(10, time.Millisecond)
+	c.Check(testSig(1), &peerConn{})
+	time.Sleep(5 * time.Millisecond)
+	if from, ok := c.Relay(testSig(1), false); ok {
+		t.Errorf("expecting an expired message not to be relayed (could echo to its origin), got: %v", from)
+	}
+}
@@ -1,23 +1,27 @@
 package netsvc
 
 import (
+	"bytes"
 	"encoding/hex"
 	"log"
 	"math/rand"
 	"net"
 	"os"
+	"slices"
+	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"code.dogecoin.org/gossip/dnet"
 	"code.dogecoin.org/gossip/node"
 	"code.dogecoin.org/governor"
 
+	"code.dogecoin.org/dogenet/internal/events"
 	"code.dogecoin.org/dogenet/internal/spec"
 )
 
 const IdealPeers = 8
+const MinChannelPeers = 2                      // minimum peers for each channel bound by a handler (on top of IdealPeers)
 const PeerLockTime = 30 * time.Second          // was 5 minutes, now 30 seconds
 const SeedAttemptTime = 60 * time.Second       // time between seed connect attempts
 const SeedAttemptRandom = 10                   // randomness in the interval, in seconds
@@ -30,43 +34,58 @@ type NetService struct {
 	bindAddrs       []spec.Address // bind-to address on THIS node
 	handlerBind     spec.BindTo
 	allowLocal      bool // allow local IP address in Announcement messages (for local testing)
+	strictHandlers  bool // disconnect handlers that send invalid messages
 	_store          spec.Store
 	store           spec.Store
 	nodeKey         dnet.KeyPair
 	newPeers        chan spec.NodeInfo
-	announceChanges chan any // send spec.Change* to Announce service
+	announceChanges chan any        // send spec.Change* to Announce service
+	seen            *seenCache      // recently seen message signatures (de-duplication)
+	scores          *scoreBoard     // peer misbehaviour scores
+	attempts        *attemptTracker // outbound connection attempts, with backoff
+	started         time.Time       // [const] time the service was created
+	handover        dnet.RawMessage // [const after Run] our key handover from an old key, if any
+	handoverTime    time.Time       // [const after Run] time of the handover
 	// MUTEX state:
 	mutex          sync.Mutex
 	connections    []net.Conn              // all current network connections (peers and handlers)
 	listen         []net.Listener          // listen sockets for peers to connect
 	connectedPeers map[MapPubKey]*peerConn // currently connected peers by pubkey
-	lockedPeers    map[MapPubKey]time.Time // peer pubkeys locked for a short time during connection attempts
+	peers          []*peerConn             // all peer connections (including handshaking)
 	socket         net.Listener            // listen socket for handlers to connect
 	handlers       []*handlerConn          // currently connected handlers
 	encAnnounce    dnet.RawMessage         // current encoded announcement, ready for sending to peers (mutex)
+	limits         Limits                  // connection limits and ban thresholds (mutex)
+	staticPeers    []spec.NodeInfo         // peers to keep connected, from config (mutex)
 }
 
 type MapPubKey = [32]byte
 
 var NoPubKey [32]byte // zeroes
 
-func New(bind []spec.Address, handlerBind spec.BindTo, nodeKey dnet.KeyPair, store spec.Store, allowLocal bool, announceChanges chan any) spec.NetSvc {
+func New(bind []spec.Address, handlerBind spec.BindTo, nodeKey dnet.KeyPair, store spec.Store, allowLocal bool, strictHandlers bool, limits Limits, announceChanges chan any) *NetService {
 	return &NetService{
 		bindAddrs:       bind,
 		handlerBind:     handlerBind,
 		allowLocal:      allowLocal,
+		strictHandlers:  strictHandlers,
 		_store:          store,
 		nodeKey:         nodeKey,
-		lockedPeers:     make(map[MapPubKey]time.Time),
 		connectedPeers:  make(map[MapPubKey]*peerConn),
 		newPeers:        make(chan spec.NodeInfo, 10),
 		announceChanges: announceChanges, // used in handler
+		seen:            newSeenCache(SeenCacheSize, SeenCacheTTL),
+		scores:          newScoreBoard(),
+		attempts:        newAttemptTracker(),
+		started:         time.Now(),
+		limits:          limits,
 	}
 }
 
 // goroutine
 func (ns *NetService) Run() {
 	ns.store = ns._store.WithCtx(ns.Context) // Service Context is first available here
+	ns.loadHandover()
 	var wg sync.WaitGroup
 	ns.startListeners(&wg)
 	go ns.acceptHandlers()
@@ -87,7 +106,84 @@ func (ns *NetService) AddPeer(node spec.NodeInfo) {
 // Receives signed announcement messages from the Announce service.
 func (ns *NetService) ReceiveAnnounce(msg dnet.RawMessage) {
 	ns.setAnnounce(msg)
-	ns.forwardToPeers(msg)
+	ns.forwardToPeers(msg, nil)
+}
+
+// SeenStats returns the message de-duplication cache hit and miss counts.
+func (ns *NetService) SeenStats() (hits uint64, misses uint64) {
+	return ns.seen.Stats()
+}
+
+// Stats returns a snapshot of connected peers and handlers.
+// called from any
+func (ns *NetService) Stats() (stats spec.ServiceStats) {
+	ns.mutex.Lock() // vs trackPeer,adoptPeer,closePeer,trackHandler,closeHandler
+	defer ns.mutex.Unlock()
+	for _, peer := range ns.connectedPeers {
+		if peer.isOutbound {
+			stats.Outbound++
+		} else {
+			stats.Inbound++
+		}
+	}
+	stats.Handlers = ns.handlerInfo()
+	stats.Uptime = int64(time.Since(ns.started).Seconds())
+	stats.SeenHits, stats.SeenMiss = ns.seen.Stats()
+	return
+}
+
+// Peers returns a snapshot of all peer connections (including handshaking)
+// called from any
+func (ns *NetService) Peers() []spec.PeerInfo {
+	ns.mutex.Lock() // vs trackPeer,adoptPeer,closePeer
+	defer ns.mutex.Unlock()
+	// peerPub is final once the peer is in connectedPeers.
+	connected := make(map[*peerConn]bool, len(ns.connectedPeers))
+	for _, peer := range ns.connectedPeers {
+		connected[peer] = true
+	}
+	peers := make([]*peerConn, len(ns.peers))
+	copy(peers, ns.peers)
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].connectedAt.Before(peers[j].connectedAt)
+	})
+	res := make([]spec.PeerInfo, 0, len(peers))
+	for _, peer := range peers {
+		res = append(res, peer.info(connected[peer]))
+	}
+	return res
+}
+
+// Handlers returns a snapshot of connected protocol handlers.
+// called from any
+func (ns *NetService) Handlers() []spec.HandlerInfo {
+	ns.mutex.Lock() // vs trackHandler,closeHandler
+	defer ns.mutex.Unlock()
+	return ns.handlerInfo()
+}
+
+// caller holds ns.mutex
+func (ns *NetService) handlerInfo() []spec.HandlerInfo {
+	res := make([]spec.HandlerInfo, 0, len(ns.handlers))
+	for _, hand := range ns.handlers {
+		res = append(res, hand.info())
+	}
+	return res
+}
+
+// DisconnectPeer closes the connection to a connected peer.
+// returns false if not connected to the peer.
+// called from any
+func (ns *NetService) DisconnectPeer(pubKey [32]byte) bool {
+	ns.mutex.Lock() // vs trackPeer,adoptPeer,closePeer
+	peer, have := ns.connectedPeers[pubKey]
+	ns.mutex.Unlock()
+	if !have {
+		return false
+	}
+	log.Printf("[%s] disconnecting peer: %v", ns.ServiceName, hex.EncodeToString(pubKey[:]))
+	ns.closePeer(peer)
+	return true
 }
 
 // called from any peer
@@ -141,6 +237,21 @@ func (ns *NetService) acceptIncoming(listner net.Listener, who string, wg *sync.
 		if err != nil {
 			log.Printf("[%s] no remote address for inbound peer: %v", who, err)
 		}
+		if ns.isBanned(nil, remote.Host) {
+			log.Printf("[%s] dropped peer, IP address is banned: %v", who, remote)
+			conn.Close()
+			continue
+		}
+		evict, err := ns.admitInbound(remoteIP(conn))
+		if err != nil {
+			log.Printf("[%s] dropped peer, %v: %v", who, err, remote)
+			conn.Close()
+			continue
+		}
+		if evict != nil {
+			log.Printf("[%s] evicting inbound peer %v to make room for: %v", who, evict.remoteIP, remote)
+			ns.closePeer(evict)
+		}
 		peer := newPeer(conn, remote, NoPubKey, false, false, ns) // inbound connection
 		if ns.trackPeer(conn, peer, NoPubKey) {
 			log.Printf("[%s] peer connected (inbound): %v", who, remote)
@@ -203,7 +314,7 @@ func (ns *NetService) gossipRandomAddresses() {
 		// send the [Node][Addr] message to peers
 		log.Printf("[Node]: gossiping a random peer address")
 		msg := dnet.ReEncodeMessage(dnet.ChannelNode, node.TagAddress, (*[32]byte)(nm.PubKey), nm.Sig, nm.Payload)
-		ns.forwardToPeers(msg)
+		ns.forwardToPeers(msg, nil)
 	}
 }
 
@@ -213,13 +324,14 @@ func (ns *NetService) findPeers() {
 	for !ns.Stopping() {
 		node := ns.choosePeer(who) // blocking
 		pubHex := hex.EncodeToString(node.PubKey[:])
-		if node.IsValid() && !ns.havePeer(node.PubKey) && ns.lockPeer(node.PubKey) {
+		if node.IsValid() && !ns.havePeer(node.PubKey) && ns.attempts.lock(node.PubKey) {
 			log.Printf("[%s] choosing peer: %v [%v]", who, node.Addr, pubHex)
 			// attempt to connect to the peer
 			d := net.Dialer{Timeout: 30 * time.Second}
 			conn, err := d.DialContext(ns.Context, "tcp", node.Addr.String())
 			if err != nil {
 				log.Printf("[%s] connect failed: %v", who, err)
+				ns.recordAttempt(node.PubKey, false)
 			} else {
 				peer := newPeer(conn, node.Addr, node.PubKey, true, true, ns) // outbound connection
 				if ns.trackPeer(conn, peer, node.PubKey) {
@@ -243,6 +355,25 @@ func (ns *NetService) choosePeer(who string) spec.NodeInfo {
 		case np := <-ns.newPeers: // from ns.AddPeer()
 			return np
 		default:
+			if np, ok := ns.nextStaticPeer(); ok {
+				// reconnect static peers regardless of IdealPeers.
+				return np
+			}
+			if needed := ns.channelsNeedingPeers(); len(needed) > 0 {
+				// connect to more peers on channels our handlers are bound to.
+				ns.Sleep(5 * time.Second) // slowly
+				np, err := ns.store.SampleNodesByChannel(needed, ns.excludePeerKeys())
+				if err != nil {
+					log.Printf("[%s] SampleNodesByChannel: %v", who, err)
+				} else {
+					// skip nodes we've recently attempted to connect to.
+					for _, idx := range rand.Perm(len(np)) {
+						if ns.tryPeer(np[idx]) {
+							return np[idx]
+						}
+					}
+				}
+			}
 			if ns.countPeers() < IdealPeers {
 				ns.Sleep(5 * time.Second) // slowly
 				np, err := ns.store.ChooseNetNode()
@@ -250,7 +381,7 @@ func (ns *NetService) choosePeer(who string) spec.NodeInfo {
 					if !spec.IsNotFoundError(err) {
 						log.Printf("[%s] ChooseNetNode: %v", who, err)
 					}
-				} else {
+				} else if ns.tryPeer(np) {
 					return np
 				}
 			}
@@ -287,27 +418,34 @@ func (ns *NetService) Stop() {
 	}
 }
 
+// forwardToPeers sends a message to all connected peers,
+// except `from` (the peer we received it from, or nil)
 // called from any
-func (ns *NetService) forwardToPeers(msg dnet.RawMessage) {
+func (ns *NetService) forwardToPeers(msg dnet.RawMessage, from *peerConn) {
 	ns.mutex.Lock() // vs countPeers,havePeer,trackPeer,adoptPeer,closePeer
 	defer ns.mutex.Unlock()
 	for _, peer := range ns.connectedPeers {
+		if peer == from {
+			continue // never echo a message back to its origin
+		}
 		// non-blocking send to peer
 		select {
 		case peer.send <- msg:
 		default:
+			droppedMessages.Inc("peer")
 		}
 	}
 }
 
+// forwardToHandlers sends a message to all handlers bound to the channel.
+// returns `found` if any handler queued the message.
 // called from any
-func (ns *NetService) forwardToHandlers(channel dnet.Tag4CC, rawHdr []byte, payload []byte) bool {
+func (ns *NetService) forwardToHandlers(channel dnet.Tag4CC, rawHdr []byte, payload []byte) (found bool) {
 	ns.mutex.Lock() // vs trackHandler,closeHandler
 	defer ns.mutex.Unlock()
-	found := false
 	for _, hand := range ns.handlers {
 		// check if the handler is listening on this channel
-		if uint32(channel) == atomic.LoadUint32(&hand.channel) {
+		if hand.isBound(channel) {
 			// non-blocking send to handler
 			select {
 			case hand.send <- dnet.RawMessage{Header: rawHdr, Payload: payload}:
@@ -316,10 +454,12 @@ func (ns *NetService) forwardToHandlers(channel dnet.Tag4CC, rawHdr []byte, payl
 				// (however there can be multiple handlers!)
 				found = true
 			default:
+				droppedMessages.Inc("handler")
+				hand.dropped.Add(1)
 			}
 		}
 	}
-	return found
+	return
 }
 
 // called from attractPeers
@@ -329,21 +469,50 @@ func (ns *NetService) countPeers() int {
 	return len(ns.connectedPeers)
 }
 
-// lockPeer reserves a peer PubKey for PeerLockTime (for connection attempts)
-// this prevents connecting to the same peer over and over
+// channelsNeedingPeers returns the channels our handlers are bound to
+// that have fewer than MinChannelPeers connected peers.
 // called from attractPeers
-func (ns *NetService) lockPeer(pubKey MapPubKey) bool {
-	ns.mutex.Lock() // vs ?? (lockedPeers is private to findPeers)
+func (ns *NetService) channelsNeedingPeers() (needed []dnet.Tag4CC) {
+	ns.mutex.Lock() // vs trackHandler,closeHandler,adoptPeer,closePeer
 	defer ns.mutex.Unlock()
-	now := time.Now()
-	if until, have := ns.lockedPeers[pubKey]; have {
-		if now.Before(until) {
-			return false // still locked
+	for _, hand := range ns.handlers {
+		for _, channel := range hand.boundChannels() {
+			if slices.Contains(needed, channel) {
+				continue // already counted
+			}
+			count := 0
+			for _, peer := range ns.connectedPeers {
+				if peer.hasChannel(channel) {
+					count++
+				}
+			}
+			if count < MinChannelPeers {
+				needed = append(needed, channel)
+			}
 		}
 	}
-	// lock the peer
-	ns.lockedPeers[pubKey] = now.Add(PeerLockTime)
-	return true
+	return
+}
+
+// excludePeerKeys returns the pubkeys of all connected peers, and this node.
+// called from attractPeers
+func (ns *NetService) excludePeerKeys() [][]byte {
+	ns.mutex.Lock() // vs trackPeer,adoptPeer,closePeer
+	defer ns.mutex.Unlock()
+	keys := make([][]byte, 0, len(ns.connectedPeers)+1)
+	keys = append(keys, ns.nodeKey.Pub[:])
+	for key := range ns.connectedPeers {
+		keys = append(keys, bytes.Clone(key[:]))
+	}
+	return keys
+}
+
+// tryPeer returns true if a node chosen from the database should be tried:
+// not recently attempted (or backing off), not banned, and passes a random
+// check that makes nodes with a history of failures less likely to be chosen.
+// called from attractPeers
+func (ns *NetService) tryPeer(np spec.NodeInfo) bool {
+	return !ns.attempts.isLocked(np.PubKey) && ns.attempts.chance(np.PubKey) && !ns.isBanned(np.PubKey[:], np.Addr.Host)
 }
 
 // havePeer returns true if we're already connected to a peer with pubKey
@@ -364,8 +533,6 @@ func (ns *NetService) trackPeer(conn net.Conn, peer *peerConn, pubKey MapPubKey)
 	if ns.Stopping() {
 		return false
 	}
-	// begin tracking the connection
-	ns.connections = append(ns.connections, conn)
 	// check if connected before tracking the peer
 	if pubKey != NoPubKey {
 		if _, have := ns.connectedPeers[pubKey]; have {
@@ -374,6 +541,10 @@ func (ns *NetService) trackPeer(conn net.Conn, peer *peerConn, pubKey MapPubKey)
 		// mark peer connected: affects future havePeer(), adoptPeer(), trackPeer() results
 		ns.connectedPeers[pubKey] = peer
 	}
+	// begin tracking the connection
+	// (only once tracked: the caller just closes conn on false)
+	ns.connections = append(ns.connections, conn)
+	ns.peers = append(ns.peers, peer)
 	return true
 }
 
@@ -394,6 +565,20 @@ func (ns *NetService) adoptPeer(peer *peerConn, pubKey MapPubKey) bool {
 func (ns *NetService) closePeer(peer *peerConn) {
 	conn := peer.conn
 	conn.Close()
+	peer.quitOnce.Do(func() {
+		close(peer.quit) // stop sendToPeer, pingPeer
+		if peer.ready.Load() {
+			// add the connection time to the node's cumulative uptime.
+			err := ns.store.AddNodeUptime(peer.peerPub[:], int64(time.Since(peer.connectedAt).Seconds()))
+			if err != nil {
+				log.Printf("[%s] AddNodeUptime: %v", ns.ServiceName, err)
+			}
+			events.Publish(events.PeerDisconnected, peer.event())
+		} else if peer.isOutbound && peer.hasPub {
+			// outbound connection closed before the handshake completed.
+			ns.recordAttempt(peer.peerPub, false)
+		}
+	})
 	ns.mutex.Lock() // vs countPeers,havePeer,trackPeer,adoptPeer,forwardToPeers,Stop
 	defer ns.mutex.Unlock()
 	// remove the peer connected status
@@ -402,6 +587,15 @@ func (ns *NetService) closePeer(peer *peerConn) {
 	if p, have := ns.connectedPeers[key]; have && p == peer {
 		delete(ns.connectedPeers, key)
 	}
+	// remove from peer connections
+	for i, p := range ns.peers {
+		if p == peer {
+			// remove from unordered array
+			ns.peers[i] = ns.peers[len(ns.peers)-1]
+			ns.peers = ns.peers[:len(ns.peers)-1]
+			break
+		}
+	}
 	// remove the tracked connnection
 	for i, c := range ns.connections {
 		if c == conn {
@@ -433,6 +627,19 @@ func (ns *NetService) trackHandler(conn net.Conn, hand *handlerConn) bool {
 func (ns *NetService) closeHandler(hand *handlerConn) {
 	conn := hand.conn
 	conn.Close()
+	if ns.untrackHandler(hand) {
+		// the handler's channels are unbound (first close only)
+		name := hand.getName()
+		for _, channel := range hand.boundChannels() {
+			events.Publish(events.HandlerUnbound, events.HandlerEvent{Name: name, Channel: channel.String()})
+		}
+	}
+}
+
+// untrackHandler removes a handler and its connection from our tracking arrays
+// returns true if the handler was tracked
+func (ns *NetService) untrackHandler(hand *handlerConn) (found bool) {
+	conn := hand.conn
 	ns.mutex.Lock() // vs trackHandler,forwardToHandlers,Stop
 	defer ns.mutex.Unlock()
 	// remove the tracked connnection
@@ -450,9 +657,11 @@ func (ns *NetService) closeHandler(hand *handlerConn) {
 			// remove from unordered array
 			ns.handlers[i] = ns.handlers[len(ns.handlers)-1]
 			ns.handlers = ns.handlers[:len(ns.handlers)-1]
+			found = true
 			break
 		}
 	}
+	return
 }
 
 // goroutine
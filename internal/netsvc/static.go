@@ -0,0 +1,56 @@
+package netsvc
+
+import (
+	"encoding/hex"
+	"log"
+
+	"code.dogecoin.org/dogenet/internal/spec"
+)
+
+// SetStaticPeers replaces the set of static peers (from --peer or the config file.)
+// Static peers are reconnected whenever they are not connected, regardless
+// of IdealPeers; removed peers stay connected until they disconnect.
+// called from any
+func (ns *NetService) SetStaticPeers(peers []spec.NodeInfo) {
+	ns.mutex.Lock() // vs unconnectedStaticPeers
+	defer ns.mutex.Unlock()
+	old := make(map[MapPubKey]spec.NodeInfo, len(ns.staticPeers))
+	for _, np := range ns.staticPeers {
+		old[np.PubKey] = np
+	}
+	for _, np := range peers {
+		if prev, have := old[np.PubKey]; have && prev.Addr.Host.Equal(np.Addr.Host) && prev.Addr.Port == np.Addr.Port {
+			delete(old, np.PubKey)
+			continue // unchanged
+		}
+		delete(old, np.PubKey)
+		log.Printf("[%s] static peer: %v [%v]", ns.ServiceName, np.Addr, hex.EncodeToString(np.PubKey[:]))
+	}
+	for _, np := range old {
+		log.Printf("[%s] removed static peer: %v [%v]", ns.ServiceName, np.Addr, hex.EncodeToString(np.PubKey[:]))
+	}
+	ns.staticPeers = append([]spec.NodeInfo(nil), peers...)
+}
+
+// nextStaticPeer returns a static peer that should be connected, if any:
+// not connected, not recently attempted (or backing off), and not banned.
+// called from attractPeers
+func (ns *NetService) nextStaticPeer() (spec.NodeInfo, bool) {
+	for _, np := range ns.unconnectedStaticPeers() {
+		if !ns.attempts.isLocked(np.PubKey) && !ns.isBanned(np.PubKey[:], np.Addr.Host) {
+			return np, true
+		}
+	}
+	return spec.NodeInfo{}, false
+}
+
+func (ns *NetService) unconnectedStaticPeers() (res []spec.NodeInfo) {
+	ns.mutex.Lock() // vs SetStaticPeers,trackPeer,adoptPeer,closePeer
+	defer ns.mutex.Unlock()
+	for _, np := range ns.staticPeers {
+		if _, have := ns.connectedPeers[np.PubKey]; !have {
+			res = append(res, np)
+		}
+	}
+	return
+}
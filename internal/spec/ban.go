@@ -0,0 +1,9 @@
+package spec
+
+// BanInfo describes a temporary ban on a peer pubkey or IP address.
+type BanInfo struct {
+	PubKey string `json:"pubkey,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Until  int64  `json:"until"` // unix timestamp
+	Reason string `json:"reason"`
+}
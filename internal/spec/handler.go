@@ -0,0 +1,34 @@
+package spec
+
+import "code.dogecoin.org/gossip/dnet"
+
+// Handler control messages.
+//
+// After the BindMessage, a handler can send signed messages on ChannelDogeNet
+// to bind more channels and to control how DogeNet treats its messages.
+// These messages are consumed by DogeNet and are never forwarded to peers.
+
+var ChannelDogeNet = dnet.NewTag("DNet") // handler <-> DogeNet control channel
+
+// [DNet][Bind] binds the handler to an additional channel.
+// Payload: an encoded dnet.BindMessage (the same as the initial bind)
+var TagBind = dnet.NewTag("Bind")
+
+// [DNet][Unbd] unbinds the handler from a channel.
+// Payload: 4-byte channel tag (big-endian)
+var TagUnbind = dnet.NewTag("Unbd")
+
+// [DNet][Rlay] asks DogeNet to relay a specific message to peers;
+// DogeNet never relays channel messages by itself, since only the
+// handler can validate them.
+// Payload: the complete signed message (header and payload) to relay.
+// The message is not sent back to the peer it was received from, and is
+// relayed at most once; it must have been received in the last 10 minutes.
+var TagRelay = dnet.NewTag("Rlay")
+
+// [DNet][Name] identifies the handler, for logs and the /handlers API.
+// Payload: VarString name, VarString version (see codec.Encoder)
+var TagName = dnet.NewTag("Name")
+
+const MaxHandlerName = 32    // maximum length of a handler name
+const MaxHandlerVersion = 32 // maximum length of a handler version
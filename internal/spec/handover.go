@@ -0,0 +1,34 @@
+package spec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// [Node][Hand] key handover, gossiped when a node rotates its key.
+// The message is signed by the OLD node key and names the new key;
+// peers mark the old pubkey as superseded and drop its node record.
+// Payload: 32-byte new pubkey, 8-byte unix time (little-endian)
+var TagHandover = dnet.NewTag("Hand")
+
+const HandoverSize = 32 + 8
+
+type Handover struct {
+	NewKey [32]byte
+	Time   int64
+}
+
+func (h Handover) Encode() []byte {
+	return binary.LittleEndian.AppendUint64(h.NewKey[:], uint64(h.Time))
+}
+
+func DecodeHandover(payload []byte) (h Handover, err error) {
+	if len(payload) != HandoverSize {
+		return h, fmt.Errorf("handover: wrong size: %v", len(payload))
+	}
+	copy(h.NewKey[:], payload[0:32])
+	h.Time = int64(binary.LittleEndian.Uint64(payload[32:40]))
+	return h, nil
+}
@@ -1,7 +1,29 @@
 package spec
 
 type NetNode struct {
-	PubKey   string `json:"pubkey"`
-	Address  string `json:"address"`
-	Identity string `json:"identity"`
+	PubKey   string        `json:"pubkey"`
+	Address  string        `json:"address"`
+	Identity string        `json:"identity"`
+	Channels []string      `json:"channels"` // channels the node participates in
+	Services []NodeService `json:"services"` // services the node runs (e.g. a Core node)
+	LastSeen int64         `json:"lastSeen"` // unix time of the node's latest announcement
+}
+
+// NodeDetail is a node's decoded announcement (/nodes/{pubkey})
+type NodeDetail struct {
+	PubKey   string        `json:"pubkey"`
+	Address  string        `json:"address"`
+	Time     int64         `json:"time"`     // unix time the announcement was signed
+	Owner    string        `json:"owner"`    // identity pubkey claimed by the node (empty if none)
+	Channels []string      `json:"channels"` // channels the node participates in
+	Services []NodeService `json:"services"` // services the node runs (e.g. a Core node)
+	Payload  string        `json:"payload"`  // hex-encoded [Node][Addr] payload
+	Sig      string        `json:"sig"`      // hex-encoded signature
+}
+
+// NodeService is a service announced by a node
+type NodeService struct {
+	Tag  string `json:"tag"`
+	Port uint16 `json:"port"`
+	Data string `json:"data"`
 }
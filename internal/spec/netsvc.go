@@ -1,6 +1,7 @@
 package spec
 
 import (
+	"code.dogecoin.org/gossip/dnet"
 	"code.dogecoin.org/governor"
 )
 
@@ -8,4 +9,10 @@ type NetSvc interface {
 	governor.Service
 	AnnounceReceiver
 	AddPeer(node NodeInfo)
+	SeenStats() (hits uint64, misses uint64) // message de-duplication cache
+	Stats() ServiceStats
+	Peers() []PeerInfo                   // live peer connections
+	Handlers() []HandlerInfo             // connected protocol handlers
+	DisconnectPeer(pubKey [32]byte) bool // false if not connected
+	GetAnnounce() dnet.RawMessage        // current signed [Node][Addr] (empty until signed)
 }
@@ -1,5 +1,7 @@
 package spec
 
+import "code.dogecoin.org/gossip/dnet"
+
 type NodeInfo struct {
 	PubKey [32]byte // array to be used as map key
 	Addr   Address
@@ -18,3 +20,13 @@ type NodeRecord struct {
 func (n NodeRecord) IsValid() bool {
 	return len(n.Payload) > 0
 }
+
+// NodeFilter selects nodes for Store.QueryNodes (zero fields match any node)
+type NodeFilter struct {
+	Channel  dnet.Tag4CC // only nodes announcing this channel
+	Owner    []byte      // only nodes claiming this owner identity
+	IPFamily int         // only IPv4 (4) or IPv6 (6) nodes
+	MaxAge   int64       // only nodes announced within this many seconds
+	After    []byte      // only nodes with a pubkey greater than this (pagination cursor)
+	Limit    int         // maximum number of nodes to return
+}
@@ -0,0 +1,55 @@
+package spec
+
+// NetStats summarises the node database (Store.NetStats)
+type NetStats struct {
+	Nodes       int            `json:"nodes"`       // number of known nodes
+	Channels    map[string]int `json:"channels"`    // number of nodes announcing each channel
+	IPv4        int            `json:"ipv4"`        // nodes with an IPv4 address
+	IPv6        int            `json:"ipv6"`        // nodes with an IPv6 address
+	AnnounceAge AnnounceAges   `json:"announceAge"` // age distribution of node announcements
+}
+
+// AnnounceAges counts node announcements by age (non-overlapping ranges)
+type AnnounceAges struct {
+	Hour  int `json:"hour"`  // less than 1 hour old
+	Day   int `json:"day"`   // 1 hour to 1 day old
+	Week  int `json:"week"`  // 1 to 7 days old
+	Month int `json:"month"` // 7 to 30 days old
+	Older int `json:"older"` // more than 30 days old
+}
+
+// ServiceStats summarises the live state of the gossip service (NetSvc.Stats)
+type ServiceStats struct {
+	Inbound  int           `json:"inbound"`  // connected inbound peers
+	Outbound int           `json:"outbound"` // connected outbound peers
+	Handlers []HandlerInfo `json:"handlers"` // connected protocol handlers
+	Uptime   int64         `json:"uptime"`   // seconds since the service started
+	SeenHits uint64        `json:"seenHits"` // duplicate messages dropped
+	SeenMiss uint64        `json:"seenMiss"` // new messages seen
+}
+
+// HandlerInfo describes a connected protocol handler (NetSvc.Handlers)
+type HandlerInfo struct {
+	Name      string   `json:"name"`      // name sent in [DNet][Name] (or "protocol-handler")
+	Version   string   `json:"version"`   // version sent in [DNet][Name]
+	Channels  []string `json:"channels"`  // channels the handler is bound to
+	Connected int64    `json:"connected"` // unix time the handler connected
+	MsgsIn    uint64   `json:"msgsIn"`    // messages received from the handler
+	MsgsOut   uint64   `json:"msgsOut"`   // messages delivered to the handler
+	Dropped   uint64   `json:"dropped"`   // messages dropped because the handler was busy
+}
+
+// PeerInfo describes a live peer connection (NetSvc.Peers)
+type PeerInfo struct {
+	PubKey    string `json:"pubkey"`    // hex pubkey (empty until the handshake reveals it)
+	Address   string `json:"address"`   // peer's announced address (or remote address until announced)
+	Direction string `json:"direction"` // "inbound" or "outbound"
+	Connected int64  `json:"connected"` // unix time the connection was established
+	Handshake bool   `json:"handshake"` // [Node][Addr] exchange has completed
+	MsgsIn    uint64 `json:"msgsIn"`    // messages received from the peer
+	MsgsOut   uint64 `json:"msgsOut"`   // messages sent to the peer
+	BytesIn   uint64 `json:"bytesIn"`   // bytes received from the peer
+	BytesOut  uint64 `json:"bytesOut"`  // bytes sent to the peer
+	SendQueue int    `json:"sendQueue"` // messages waiting in the send queue
+	RTT       int64  `json:"rtt"`       // last ping round-trip time in milliseconds (0 if not measured)
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 
 	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
 )
 
 const SecondsPerDay = 24 * 60 * 60
@@ -19,20 +20,32 @@ const MaxCoreNodeDays = 3
 type Store interface {
 	WithCtx(ctx context.Context) Store
 	// common
-	NetStats() (mapSize int, err error)
+	NetStats() (stats NetStats, err error)
 	NodeList() (net []NetNode, err error)
+	QueryNodes(filter NodeFilter) (net []NetNode, err error) // ordered by pubkey
+	GetNetNode(key []byte) (NodeRecord, error)               // NotFoundError if not found
 	TrimNodes() (advanced bool, remNode int64, err error)
 	// dogenet nodes
 	GetAnnounce() (payload []byte, sig []byte, time int64, owner []byte, err error)
 	SetAnnounce(payload []byte, sig []byte, time int64) error
 	SetAnnounceOwner(owner []byte) error
-	AddNetNode(key []byte, address Address, time int64, owner []byte, channels []dnet.Tag4CC, payload []byte, sig []byte) (changed bool, err error)
+	AddNetNode(key []byte, address Address, time int64, owner []byte, channels []dnet.Tag4CC, services []node.Service, payload []byte, sig []byte) (changed bool, err error)
 	UpdateNetTime(key []byte) error
-	ChooseNetNode() (NodeInfo, error)
+	ChooseNetNode() (NodeInfo, error) // weighted by connection history
+	RecordConnect(key []byte, ok bool) error
+	AddNodeUptime(key []byte, seconds int64) error
 	ChooseNetNodeMsg() (NodeRecord, error)
 	SampleNodesByChannel(channels []dnet.Tag4CC, exclude [][]byte) ([]NodeInfo, error)
 	SampleNodesByIP(ipaddr net.IP, exclude [][]byte) ([]NodeInfo, error)
+	// key handovers (see TagHandover)
+	SupersedeNode(key []byte, newKey []byte, time int64, payload []byte, sig []byte, local bool) (isnew bool, err error)
+	GetHandover(newKey []byte) (NodeRecord, error) // local handovers only; NotFoundError if none
 	// registered channels
 	GetChannels() (channels []dnet.Tag4CC, err error)
 	AddChannel(channel dnet.Tag4CC) error
+	// peer bans (key is a 32-byte pubkey or 16-byte IP address)
+	AddBan(key []byte, until int64, reason string) error
+	IsBanned(key []byte) (banned bool, err error)
+	ListBans() (bans []BanInfo, err error)
+	RemoveBans(key []byte) (removed int64, err error) // nil key: remove all bans
 }
@@ -0,0 +1,8 @@
+package store
+
+import "code.dogecoin.org/dogenet/internal/metrics"
+
+var (
+	txnRetries   = metrics.NewCounterVec("dogenet_store_txn_retries_total", "Store transactions retried due to database conflicts.", "txn")
+	trimmedNodes = metrics.NewCounter("dogenet_store_trimmed_nodes_total", "Expired nodes removed by the store trimmer.")
+)
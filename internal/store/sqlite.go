@@ -7,18 +7,32 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"math"
+	"math/rand"
 	"net"
+	"strings"
 	"time"
 
+	"code.dogecoin.org/dogenet/internal/events"
 	"code.dogecoin.org/dogenet/internal/spec"
 	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
 	"github.com/mattn/go-sqlite3"
 )
 
 type NodeID = spec.NodeID
 type Address = spec.Address
 
+// Maximum number of nodes returned by SampleNodesByChannel and SampleNodesByIP.
+const SampleNodesLimit = 20
+
+// Fraction of ChooseNetNode calls that choose uniformly at random,
+// to explore nodes regardless of their connection history.
+const ExploreChance = 0.2
+
+// Number of random nodes ChooseNetNode chooses from.
+const ChooseSampleSize = 100
+
 // SELECT * FROM table WHERE id IN (SELECT id FROM table ORDER BY RANDOM() LIMIT 10)
 
 type SQLiteStore struct {
@@ -75,11 +89,58 @@ const SQL_MIGRATION_v2 string = `
 ALTER TABLE announce ADD COLUMN owner BLOB
 `
 
+const SQL_MIGRATION_v3 string = `
+CREATE TABLE IF NOT EXISTS ban (
+	key BLOB NOT NULL PRIMARY KEY,
+	until INTEGER NOT NULL,
+	reason TEXT NOT NULL
+)
+`
+
+const SQL_MIGRATION_v4 string = `
+ALTER TABLE node ADD COLUMN lastok INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE node ADD COLUMN lastfail INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE node ADD COLUMN okcount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE node ADD COLUMN failcount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE node ADD COLUMN uptime INTEGER NOT NULL DEFAULT 0;
+`
+
+const SQL_MIGRATION_v5 string = `
+CREATE TABLE IF NOT EXISTS service (
+	node INTEGER NOT NULL,
+	tag TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS service_node_i ON service (node);
+`
+
+const SQL_MIGRATION_v6 string = `
+CREATE TABLE IF NOT EXISTS superseded (
+	key BLOB NOT NULL PRIMARY KEY,
+	newkey BLOB NOT NULL,
+	time INTEGER NOT NULL,
+	payload BLOB NOT NULL,
+	sig BLOB NOT NULL,
+	dayc INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS superseded_newkey_i ON superseded (newkey);
+`
+
+const SQL_MIGRATION_v7 string = `
+ALTER TABLE superseded ADD COLUMN local INTEGER NOT NULL DEFAULT 0;
+`
+
 var MIGRATIONS = []struct {
 	ver   int
 	query string
 }{
 	{2, SQL_MIGRATION_v2},
+	{3, SQL_MIGRATION_v3},
+	{4, SQL_MIGRATION_v4},
+	{5, SQL_MIGRATION_v5},
+	{6, SQL_MIGRATION_v6},
+	{7, SQL_MIGRATION_v7},
 }
 
 // NewSQLiteStore returns a spec.Store implementation that uses SQLite
@@ -191,6 +252,7 @@ func (s SQLiteStore) doTxn(name string, work func(tx *sql.Tx) error) error {
 		tx, err := s.db.Begin()
 		if err != nil {
 			if IsConflict(err) {
+				txnRetries.Inc(name)
 				s.Sleep(250 * time.Millisecond)
 				limit--
 				if limit != 0 {
@@ -203,6 +265,7 @@ func (s SQLiteStore) doTxn(name string, work func(tx *sql.Tx) error) error {
 		err = work(tx)
 		if err != nil {
 			if IsConflict(err) {
+				txnRetries.Inc(name)
 				s.Sleep(250 * time.Millisecond)
 				limit--
 				if limit != 0 {
@@ -214,6 +277,7 @@ func (s SQLiteStore) doTxn(name string, work func(tx *sql.Tx) error) error {
 		err = tx.Commit()
 		if err != nil {
 			if IsConflict(err) {
+				txnRetries.Inc(name)
 				s.Sleep(250 * time.Millisecond)
 				limit--
 				if limit != 0 {
@@ -255,16 +319,46 @@ func dbErr(err error, where string) error {
 
 // STORE INTERFACE
 
-func (s SQLiteStore) NetStats() (mapSize int, err error) {
+func (s SQLiteStore) NetStats() (stats spec.NetStats, err error) {
 	err = s.doTxn("NetStats", func(tx *sql.Tx) error {
-		row := tx.QueryRow("SELECT COUNT(key) AS num FROM node")
-		err := row.Scan(&mapSize)
+		now := time.Now().Unix()
+		// IPv4 addresses are stored as IPv4-mapped IPv6 addresses.
+		row := tx.QueryRow(`SELECT COUNT(key),
+			COALESCE(SUM(substr(address,1,12) = x'00000000000000000000ffff'),0),
+			COALESCE(SUM(time >= ?),0),
+			COALESCE(SUM(time < ? AND time >= ?),0),
+			COALESCE(SUM(time < ? AND time >= ?),0),
+			COALESCE(SUM(time < ? AND time >= ?),0),
+			COALESCE(SUM(time < ?),0) FROM node`,
+			now-3600,
+			now-3600, now-spec.SecondsPerDay,
+			now-spec.SecondsPerDay, now-7*spec.SecondsPerDay,
+			now-7*spec.SecondsPerDay, now-30*spec.SecondsPerDay,
+			now-30*spec.SecondsPerDay)
+		ages := &stats.AnnounceAge
+		err := row.Scan(&stats.Nodes, &stats.IPv4, &ages.Hour, &ages.Day, &ages.Week, &ages.Month, &ages.Older)
 		if err != nil {
-			// special case: always return nil (no stats) errors.
-			if err != sql.ErrNoRows {
-				log.Printf("[Store] NetStats: %v", err)
+			return fmt.Errorf("[Store] NetStats: query: %v", err)
+		}
+		stats.IPv6 = stats.Nodes - stats.IPv4
+		// NB. AddNetNode stores channels in the `chan` table as 4CC strings.
+		stats.Channels = make(map[string]int)
+		rows, err := tx.Query("SELECT chan.chan,COUNT(*) FROM chan JOIN node ON node.oid=chan.node GROUP BY chan.chan")
+		if err != nil {
+			return fmt.Errorf("[Store] NetStats: query: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var channel string
+			var count int
+			err := rows.Scan(&channel, &count)
+			if err != nil {
+				return fmt.Errorf("[Store] NetStats: scanning row: %v", err)
 			}
-			return nil
+			stats.Channels[channel] = count
+		}
+		if err = rows.Err(); err != nil { // docs say this check is required!
+			return fmt.Errorf("[Store] NetStats: query: %v", err)
 		}
 		return nil
 	})
@@ -275,40 +369,173 @@ var ZeroIdentity [32]byte // zero-bytes
 
 func (s SQLiteStore) NodeList() (netList []spec.NetNode, err error) {
 	err = s.doTxn("NodeList", func(tx *sql.Tx) error {
-		rows, err := tx.Query("SELECT key,address,owner FROM node")
+		rows, err := tx.Query("SELECT oid,key,address,owner,time FROM node")
 		if err != nil {
 			return fmt.Errorf("[Store] netNodeList: query: %v", err)
 		}
-		defer rows.Close()
+		netList, err = scanNetNodes(tx, rows)
+		return err
+	})
+	return
+}
+
+// QueryNodes returns nodes matching the filter, ordered by pubkey.
+func (s SQLiteStore) QueryNodes(filter spec.NodeFilter) (netList []spec.NetNode, err error) {
+	var where []string
+	var args []any
+	if filter.Channel != 0 {
+		// NB. AddNetNode stores channels in the `chan` table as 4CC strings.
+		where = append(where, "oid IN (SELECT node FROM chan WHERE chan=?)")
+		args = append(args, filter.Channel.String())
+	}
+	if filter.Owner != nil {
+		where = append(where, "owner=?")
+		args = append(args, filter.Owner)
+	}
+	switch filter.IPFamily {
+	case 0:
+	case 4: // IPv4 addresses are stored as IPv4-mapped IPv6 addresses.
+		where = append(where, "substr(address,1,12) = x'00000000000000000000ffff'")
+	case 6:
+		where = append(where, "substr(address,1,12) != x'00000000000000000000ffff'")
+	default:
+		return nil, fmt.Errorf("QueryNodes: invalid IP family: %v", filter.IPFamily)
+	}
+	if filter.MaxAge > 0 {
+		where = append(where, "time >= ?")
+		args = append(args, time.Now().Unix()-filter.MaxAge)
+	}
+	if filter.After != nil {
+		where = append(where, "key > ?")
+		args = append(args, filter.After)
+	}
+	query := "SELECT oid,key,address,owner,time FROM node"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY key"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	err = s.doTxn("QueryNodes", func(tx *sql.Tx) error {
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("[Store] QueryNodes: query: %v", err)
+		}
+		netList, err = scanNetNodes(tx, rows)
+		return err
+	})
+	return
+}
+
+// scanNetNodes reads (oid,key,address,owner,time) rows into NetNode,
+// including channels and services; closes rows.
+func scanNetNodes(tx *sql.Tx, rows *sql.Rows) (netList []spec.NetNode, err error) {
+	var oids []int64
+	defer rows.Close()
+	for rows.Next() {
+		var oid int64
+		var pubkey []byte
+		var address []byte // 18-byte host:port from Address.ToBytes()
+		var owner []byte
+		var ts int64
+		err := rows.Scan(&oid, &pubkey, &address, &owner, &ts)
+		if err != nil {
+			return nil, fmt.Errorf("[Store] netNodeList: scanning row: %v", err)
+		}
+		addr, err := dnet.AddressFromBytes(address)
+		if err != nil {
+			return nil, fmt.Errorf("[Store] netNodeList: invalid address: %v", err)
+		}
+		// required for API spec.
+		if bytes.Equal(owner, ZeroIdentity[:]) {
+			owner = []byte{} // becomes empty-string
+		}
+		// string-encode and normalize for API spec.
+		netList = append(netList, spec.NetNode{
+			PubKey:   hex.EncodeToString(pubkey),
+			Address:  normalizeIP4(addr).String(),
+			Identity: hex.EncodeToString(owner),
+			Channels: []string{},
+			Services: []spec.NodeService{},
+			LastSeen: ts,
+		})
+		oids = append(oids, oid)
+	}
+	if err = rows.Err(); err != nil { // docs say this check is required!
+		return nil, fmt.Errorf("[Store] query: %v", err)
+	}
+	rows.Close() // before running more queries
+	err = nodeChannelsServices(tx, oids, netList)
+	if err != nil {
+		return nil, err
+	}
+	return netList, nil
+}
+
+const maxQueryParams = 500 // nodes per query (SQLite limits bound parameters)
+
+// nodeChannelsServices reads the channels and services of the nodes,
+// with one query per table for each batch of nodes; `oids[i]` is the
+// oid of `netList[i]`.
+func nodeChannelsServices(tx *sql.Tx, oids []int64, netList []spec.NetNode) error {
+	index := make(map[int64]int, len(oids))
+	for i, oid := range oids {
+		index[oid] = i
+	}
+	for len(oids) > 0 {
+		batch := oids
+		if len(batch) > maxQueryParams {
+			batch = batch[:maxQueryParams]
+		}
+		oids = oids[len(batch):]
+		args := make([]any, len(batch))
+		for i, oid := range batch {
+			args[i] = oid
+		}
+		in := placeholders(len(batch))
+		// NB. AddNetNode stores channels in the `chan` table as 4CC strings.
+		rows, err := tx.Query("SELECT node,chan FROM chan WHERE node IN ("+in+") ORDER BY node,chan", args...)
+		if err != nil {
+			return fmt.Errorf("[Store] netNodeList: query channels: %v", err)
+		}
 		for rows.Next() {
-			var pubkey []byte
-			var address []byte // 18-byte host:port from Address.ToBytes()
-			var owner []byte
-			err := rows.Scan(&pubkey, &address, &owner)
-			if err != nil {
-				return fmt.Errorf("[Store] netNodeList: scanning row: %v", err)
+			var oid int64
+			var channel string
+			if err := rows.Scan(&oid, &channel); err != nil {
+				rows.Close()
+				return fmt.Errorf("[Store] netNodeList: scanning channel: %v", err)
 			}
-			addr, err := dnet.AddressFromBytes(address)
-			if err != nil {
-				return fmt.Errorf("[Store] netNodeList: invalid address: %v", err)
-			}
-			// required for API spec.
-			if bytes.Equal(owner, ZeroIdentity[:]) {
-				owner = []byte{} // becomes empty-string
+			n := &netList[index[oid]]
+			n.Channels = append(n.Channels, channel)
+		}
+		err = rows.Err() // docs say this check is required!
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("[Store] query: %v", err)
+		}
+		rows, err = tx.Query("SELECT node,tag,port,data FROM service WHERE node IN ("+in+") ORDER BY node,rowid", args...)
+		if err != nil {
+			return fmt.Errorf("[Store] netNodeList: query services: %v", err)
+		}
+		for rows.Next() {
+			var oid int64
+			var svc spec.NodeService
+			if err := rows.Scan(&oid, &svc.Tag, &svc.Port, &svc.Data); err != nil {
+				rows.Close()
+				return fmt.Errorf("[Store] netNodeList: scanning service: %v", err)
 			}
-			// string-encode and normalize for API spec.
-			netList = append(netList, spec.NetNode{
-				PubKey:   hex.EncodeToString(pubkey),
-				Address:  normalizeIP4(addr).String(),
-				Identity: hex.EncodeToString(owner),
-			})
+			n := &netList[index[oid]]
+			n.Services = append(n.Services, svc)
 		}
-		if err = rows.Err(); err != nil { // docs say this check is required!
+		err = rows.Err() // docs say this check is required!
+		rows.Close()
+		if err != nil {
 			return fmt.Errorf("[Store] query: %v", err)
 		}
-		return nil
-	})
-	return
+	}
+	return nil
 }
 
 // normalizeIP4 normalizes an Address to IPv4 if possible.
@@ -332,7 +559,9 @@ func normalizeIP4(addr spec.Address) spec.Address {
 //
 // This causes expiry to lag by the number of offline days.
 func (s SQLiteStore) TrimNodes() (advanced bool, remNode int64, err error) {
+	var expired [][]byte
 	err = s.doTxn("TrimNodes", func(tx *sql.Tx) error {
+		expired = nil // in case of retry
 		// check if date has changed
 		row := tx.QueryRow("SELECT dayc,last FROM config LIMIT 1")
 		var dayc int64
@@ -352,6 +581,22 @@ func (s SQLiteStore) TrimNodes() (advanced bool, remNode int64, err error) {
 			}
 
 			// expire net nodes
+			rows, err := tx.Query("SELECT key FROM node WHERE dayc < ?", dayc)
+			if err != nil {
+				return fmt.Errorf("TrimNodes: SELECT node: %v", err)
+			}
+			for rows.Next() {
+				var key []byte
+				if err := rows.Scan(&key); err != nil {
+					rows.Close()
+					return fmt.Errorf("TrimNodes: scanning row: %v", err)
+				}
+				expired = append(expired, key)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil { // docs say this check is required!
+				return fmt.Errorf("TrimNodes: SELECT node: %v", err)
+			}
 			res, err := tx.Exec("DELETE FROM node WHERE dayc < ?", dayc)
 			if err != nil {
 				return fmt.Errorf("TrimNodes: DELETE node: %v", err)
@@ -361,18 +606,40 @@ func (s SQLiteStore) TrimNodes() (advanced bool, remNode int64, err error) {
 				return fmt.Errorf("TrimNodes: rows-affected: %v", err)
 			}
 
+			// remove channels and services of expired nodes
+			_, err = tx.Exec("DELETE FROM chan WHERE node NOT IN (SELECT oid FROM node)")
+			if err != nil {
+				return fmt.Errorf("TrimNodes: DELETE chan: %v", err)
+			}
+			_, err = tx.Exec("DELETE FROM service WHERE node NOT IN (SELECT oid FROM node)")
+			if err != nil {
+				return fmt.Errorf("TrimNodes: DELETE service: %v", err)
+			}
+
 			// expire channels
-			res, err = tx.Exec("DELETE FROM channels WHERE dayc < ?", dayc)
+			_, err = tx.Exec("DELETE FROM channels WHERE dayc < ?", dayc)
 			if err != nil {
 				return fmt.Errorf("TrimNodes: DELETE channel: %v", err)
 			}
-			remNode, err = res.RowsAffected()
+
+			// expire key handovers (once old announcements have expired)
+			_, err = tx.Exec("DELETE FROM superseded WHERE dayc < ?", dayc)
 			if err != nil {
-				return fmt.Errorf("TrimNodes: rows-affected: %v", err)
+				return fmt.Errorf("TrimNodes: DELETE superseded: %v", err)
 			}
 		}
+		// expire bans (not tied to the day-count)
+		_, err = tx.Exec("DELETE FROM ban WHERE until <= ?", time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("TrimNodes: DELETE ban: %v", err)
+		}
 		return nil
 	})
+	if err == nil {
+		for _, key := range expired {
+			events.Publish(events.NodeExpired, events.NodeEvent{PubKey: hex.EncodeToString(key)})
+		}
+	}
 	return
 }
 
@@ -467,12 +734,20 @@ func (s SQLiteStore) AddChannel(channel dnet.Tag4CC) error {
 // const add_netnode_psql = "INSERT INTO node (key, address, time, owner, payload, sig, dayc) VALUES (?1,?2,?3,?4,?5,?6,30+(SELECT dayc FROM config LIMIT 1)) ON CONFLICT ON CONSTRAINT node_key DO UPDATE SET address=?2, time=?3, owner=?4, payload=?5, sig=?6, dayc=30+(SELECT dayc FROM config LIMIT 1)"
 // const add_netnode_sqlite = "INSERT INTO node (key, address, time, owner, payload, sig, dayc) VALUES (?1,?2,?3,?4,?5,?6,30+(SELECT dayc FROM config LIMIT 1)) ON CONFLICT REPLACE RETURNING oid"
 
-func (s SQLiteStore) AddNetNode(key []byte, address Address, time int64, owner []byte, channels []dnet.Tag4CC, payload []byte, sig []byte) (changed bool, err error) {
+func (s SQLiteStore) AddNetNode(key []byte, address Address, time int64, owner []byte, channels []dnet.Tag4CC, services []node.Service, payload []byte, sig []byte) (changed bool, err error) {
+	inserted := false
 	err = s.doTxn("AddNetNode", func(tx *sql.Tx) error {
-		row := tx.QueryRow("SELECT oid,payload FROM node WHERE key=? LIMIT 1", key)
+		changed, inserted = false, false // in case of retry
 		var oid int64
+		e := tx.QueryRow("SELECT 1 FROM superseded WHERE key=?", key).Scan(&oid)
+		if e == nil {
+			return nil // key was handed over to a new key: ignore old announcements.
+		} else if !errors.Is(e, sql.ErrNoRows) {
+			return fmt.Errorf("query superseded: %v", e)
+		}
+		row := tx.QueryRow("SELECT oid,payload FROM node WHERE key=? LIMIT 1", key)
 		var stored []byte
-		e := row.Scan(&oid, &stored)
+		e = row.Scan(&oid, &stored)
 		if e != nil {
 			// no rows found, or an error.
 			if !errors.Is(e, sql.ErrNoRows) {
@@ -488,6 +763,7 @@ func (s SQLiteStore) AddNetNode(key []byte, address Address, time int64, owner [
 			if e != nil {
 				return fmt.Errorf("lastid: %v", e)
 			}
+			inserted = true
 		} else {
 			if bytes.Equal(stored, payload) {
 				return nil // existing row has the same payload: no change.
@@ -513,9 +789,114 @@ func (s SQLiteStore) AddNetNode(key []byte, address Address, time int64, owner [
 				return fmt.Errorf("insert channel: %v", e)
 			}
 		}
+		_, e = tx.Exec("DELETE FROM service WHERE node=?", oid)
+		if e != nil {
+			return fmt.Errorf("delete services: %v", e)
+		}
+		ins, e = tx.Prepare("INSERT INTO service (node,tag,port,data) VALUES (?,?,?,?)")
+		if e != nil {
+			return fmt.Errorf("prepare: %v", e)
+		}
+		for _, svc := range services {
+			_, e = ins.Exec(oid, svc.Tag.String(), svc.Port, svc.Data)
+			if e != nil {
+				return fmt.Errorf("insert service: %v", e)
+			}
+		}
 		changed = true
 		return nil
 	})
+	if err == nil && changed {
+		ev := events.NodeEvent{PubKey: hex.EncodeToString(key), Address: normalizeIP4(address).String()}
+		if inserted {
+			events.Publish(events.NodeAdded, ev)
+		} else {
+			events.Publish(events.NodeUpdated, ev)
+		}
+	}
+	return
+}
+
+// SupersedeNode records a key handover from `key` to `newKey` and removes
+// the node record for `key`. Returns false if the handover is already known.
+// `local` marks our own handover (from `key rotate`), see GetHandover.
+func (s SQLiteStore) SupersedeNode(key []byte, newKey []byte, time int64, payload []byte, sig []byte, local bool) (isnew bool, err error) {
+	removed := false
+	err = s.doTxn("SupersedeNode", func(tx *sql.Tx) error {
+		isnew, removed = false, false // in case of retry
+		res, e := tx.Exec("INSERT OR IGNORE INTO superseded (key,newkey,time,payload,sig,local,dayc) VALUES (?,?,?,?,?,?,30+(SELECT dayc FROM config LIMIT 1))",
+			key, newKey, time, payload, sig, local)
+		if e != nil {
+			return fmt.Errorf("insert: %v", e)
+		}
+		num, e := res.RowsAffected()
+		if e != nil {
+			return fmt.Errorf("rows-affected: %v", e)
+		}
+		if num == 0 {
+			return nil // already superseded
+		}
+		isnew = true
+		var oid int64
+		e = tx.QueryRow("SELECT oid FROM node WHERE key=?", key).Scan(&oid)
+		if e != nil {
+			if errors.Is(e, sql.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("query: %v", e)
+		}
+		for _, table := range []string{"chan", "service"} {
+			_, e = tx.Exec("DELETE FROM "+table+" WHERE node=?", oid)
+			if e != nil {
+				return fmt.Errorf("delete %v: %v", table, e)
+			}
+		}
+		_, e = tx.Exec("DELETE FROM node WHERE oid=?", oid)
+		if e != nil {
+			return fmt.Errorf("delete node: %v", e)
+		}
+		removed = true
+		return nil
+	})
+	if err == nil && removed {
+		events.Publish(events.NodeExpired, events.NodeEvent{PubKey: hex.EncodeToString(key)})
+	}
+	return
+}
+
+// GetHandover returns our own (local) handover message naming `newKey`
+// (the signed message is from the old key.) Handovers received from peers
+// are never returned, since any key can sign one naming `newKey`.
+// NotFoundError if there is none.
+func (s SQLiteStore) GetHandover(newKey []byte) (r spec.NodeRecord, err error) {
+	err = s.doTxn("GetHandover", func(tx *sql.Tx) error {
+		row := tx.QueryRow("SELECT key,payload,sig FROM superseded WHERE newkey=? AND local=1 ORDER BY time DESC LIMIT 1", newKey)
+		err := row.Scan(&r.PubKey, &r.Payload, &r.Sig)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return spec.NotFoundError
+			} else {
+				return fmt.Errorf("query: %v", err)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) GetNetNode(key []byte) (r spec.NodeRecord, err error) {
+	err = s.doTxn("GetNetNode", func(tx *sql.Tx) error {
+		row := tx.QueryRow("SELECT key,payload,sig FROM node WHERE key=?", key)
+		err := row.Scan(&r.PubKey, &r.Payload, &r.Sig)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return spec.NotFoundError
+			} else {
+				return fmt.Errorf("query: %v", err)
+			}
+		}
+		return nil
+	})
 	return
 }
 
@@ -530,26 +911,89 @@ func (s SQLiteStore) UpdateNetTime(key []byte) (err error) {
 	return
 }
 
+// ChooseNetNode chooses a random node, favouring nodes with a history of
+// successful connections. Nodes are weighted by their smoothed success rate
+// (okcount+1)/(okcount+failcount+2), so unknown nodes have a weight of 0.5;
+// a fraction of choices (ExploreChance) ignore the weights entirely.
+// The choice is made from a random sample of ChooseSampleSize nodes, with
+// probability proportional to weight (Efraimidis-Spirakis: the largest
+// ln(U)/w for uniform U, computed here because SQLite has no ln())
 func (s SQLiteStore) ChooseNetNode() (res spec.NodeInfo, err error) {
+	type candidate struct {
+		key, addr []byte
+		weight    float64
+	}
+	var sample []candidate
 	err = s.doTxn("ChooseNetNode", func(tx *sql.Tx) error {
-		row := tx.QueryRow("SELECT key,address FROM node WHERE oid IN (SELECT oid FROM node ORDER BY RANDOM() LIMIT 1)")
-		var key []byte
-		var addr []byte
-		err := row.Scan(&key, &addr)
+		sample = nil // in case of retry
+		rows, err := tx.Query("SELECT key,address,okcount,failcount FROM node ORDER BY RANDOM() LIMIT ?", ChooseSampleSize)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return spec.NotFoundError
-			} else {
-				return fmt.Errorf("query: %v", err)
+			return fmt.Errorf("query: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c candidate
+			var okcount, failcount int64
+			if err := rows.Scan(&c.key, &c.addr, &okcount, &failcount); err != nil {
+				return fmt.Errorf("scanning row: %v", err)
 			}
+			c.weight = (float64(okcount) + 1) / (float64(okcount+failcount) + 2)
+			sample = append(sample, c)
 		}
-		if len(key) != 32 {
-			return fmt.Errorf("invalid node key: %v (should be 32 bytes)", hex.EncodeToString(key))
+		if err = rows.Err(); err != nil { // docs say this check is required!
+			return fmt.Errorf("query: %v", err)
 		}
-		res.PubKey = *(*[32]byte)(key) // Go 1.17
-		res.Addr, err = dnet.AddressFromBytes(addr)
-		if err != nil {
-			return fmt.Errorf("invalid address: %v", err)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if len(sample) < 1 {
+		return res, spec.NotFoundError
+	}
+	choice := rand.Intn(len(sample)) // uniform (the sample is in random order)
+	if rand.Float64() >= ExploreChance {
+		best := math.Inf(-1)
+		for i, c := range sample {
+			k := math.Log(1-rand.Float64()) / c.weight // 1-U is in (0,1]
+			if k > best {
+				best, choice = k, i
+			}
+		}
+	}
+	c := sample[choice]
+	if len(c.key) != 32 {
+		return res, fmt.Errorf("invalid node key: %v (should be 32 bytes)", hex.EncodeToString(c.key))
+	}
+	res.PubKey = *(*[32]byte)(c.key) // Go 1.17
+	res.Addr, err = dnet.AddressFromBytes(c.addr)
+	if err != nil {
+		return res, fmt.Errorf("invalid address: %v", err)
+	}
+	return res, nil
+}
+
+func (s SQLiteStore) RecordConnect(key []byte, ok bool) (err error) {
+	err = s.doTxn("RecordConnect", func(tx *sql.Tx) error {
+		var e error
+		if ok {
+			_, e = tx.Exec("UPDATE node SET lastok=?, okcount=okcount+1 WHERE key=?", time.Now().Unix(), key)
+		} else {
+			_, e = tx.Exec("UPDATE node SET lastfail=?, failcount=failcount+1 WHERE key=?", time.Now().Unix(), key)
+		}
+		if e != nil {
+			return fmt.Errorf("update: %v", e)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) AddNodeUptime(key []byte, seconds int64) (err error) {
+	err = s.doTxn("AddNodeUptime", func(tx *sql.Tx) error {
+		_, e := tx.Exec("UPDATE node SET uptime=uptime+? WHERE key=?", seconds, key)
+		if e != nil {
+			return fmt.Errorf("update: %v", e)
 		}
 		return nil
 	})
@@ -573,14 +1017,181 @@ func (s SQLiteStore) ChooseNetNodeMsg() (r spec.NodeRecord, err error) {
 }
 
 func (s SQLiteStore) SampleNodesByChannel(channels []dnet.Tag4CC, exclude [][]byte) (res []spec.NodeInfo, err error) {
+	if len(channels) < 1 {
+		return // no channels: no nodes.
+	}
 	err = s.doTxn("SampleNodesByChannel", func(tx *sql.Tx) error {
-		return nil
+		// NB. AddNetNode stores channels in the `chan` table as 4CC strings.
+		args := make([]any, 0, len(channels)+len(exclude)+1)
+		for _, ch := range channels {
+			args = append(args, ch.String())
+		}
+		for _, key := range exclude {
+			args = append(args, key)
+		}
+		args = append(args, SampleNodesLimit)
+		rows, err := tx.Query("SELECT key,address FROM node WHERE oid IN (SELECT node FROM chan WHERE chan IN ("+placeholders(len(channels))+")) AND key NOT IN ("+placeholders(len(exclude))+") ORDER BY RANDOM() LIMIT ?", args...)
+		if err != nil {
+			return fmt.Errorf("query: %v", err)
+		}
+		res, err = scanNodeInfo(rows)
+		return err
 	})
 	return
 }
 
 func (s SQLiteStore) SampleNodesByIP(ipaddr net.IP, exclude [][]byte) (res []spec.NodeInfo, err error) {
+	// addresses are stored as 16-byte IPv6 (or IPv4-mapped) host + 2-byte port;
+	// the subnet is the /16 for IPv4 addresses, or the /32 for IPv6 addresses.
+	prefix := subnetPrefix(ipaddr)
+	if prefix == nil {
+		return nil, fmt.Errorf("SampleNodesByIP: invalid IP address: %v", ipaddr)
+	}
 	err = s.doTxn("SampleNodesByIP", func(tx *sql.Tx) error {
+		args := make([]any, 0, len(exclude)+3)
+		args = append(args, len(prefix), prefix)
+		for _, key := range exclude {
+			args = append(args, key)
+		}
+		args = append(args, SampleNodesLimit)
+		rows, err := tx.Query("SELECT key,address FROM node WHERE substr(address,1,?) != ? AND key NOT IN ("+placeholders(len(exclude))+") ORDER BY RANDOM() LIMIT ?", args...)
+		if err != nil {
+			return fmt.Errorf("query: %v", err)
+		}
+		res, err = scanNodeInfo(rows)
+		return err
+	})
+	return
+}
+
+// subnetPrefix returns the leading bytes of the 16-byte form of ip
+// that identify its subnet: /16 for IPv4 and /32 for IPv6.
+func subnetPrefix(ip net.IP) []byte {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil
+	}
+	if ip.To4() != nil {
+		return ip16[0:14] // IPv4-mapped prefix (12 bytes) + /16
+	}
+	return ip16[0:4] // IPv6 /32
+}
+
+// placeholders returns a list of `n` SQL parameters, e.g. "?,?,?"
+func placeholders(n int) string {
+	if n < 1 {
+		return ""
+	}
+	return strings.Repeat(",?", n)[1:]
+}
+
+// scanNodeInfo reads (key,address) rows into NodeInfo; closes rows.
+func scanNodeInfo(rows *sql.Rows) (res []spec.NodeInfo, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var key []byte
+		var addr []byte
+		err = rows.Scan(&key, &addr)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid node key: %v (should be 32 bytes)", hex.EncodeToString(key))
+		}
+		var info spec.NodeInfo
+		info.PubKey = *(*[32]byte)(key) // Go 1.17
+		info.Addr, err = dnet.AddressFromBytes(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %v", err)
+		}
+		res = append(res, info)
+	}
+	if err = rows.Err(); err != nil { // docs say this check is required!
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	return res, nil
+}
+
+func (s SQLiteStore) AddBan(key []byte, until int64, reason string) error {
+	return s.doTxn("AddBan", func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE ban SET until=MAX(until,?), reason=? WHERE key=?", until, reason, key)
+		if err != nil {
+			return err
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if num == 0 {
+			_, err = tx.Exec("INSERT INTO ban (key,until,reason) VALUES (?,?,?)", key, until, reason)
+		}
+		return err
+	})
+}
+
+func (s SQLiteStore) IsBanned(key []byte) (banned bool, err error) {
+	err = s.doTxn("IsBanned", func(tx *sql.Tx) error {
+		row := tx.QueryRow("SELECT 1 FROM ban WHERE key=? AND until>?", key, time.Now().Unix())
+		var one int
+		e := row.Scan(&one)
+		if e != nil {
+			if !errors.Is(e, sql.ErrNoRows) {
+				return fmt.Errorf("query: %v", e)
+			}
+			return nil
+		}
+		banned = true
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) ListBans() (bans []spec.BanInfo, err error) {
+	err = s.doTxn("ListBans", func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT key,until,reason FROM ban WHERE until>? ORDER BY until", time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("[Store] ListBans: query: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key []byte
+			var ban spec.BanInfo
+			err := rows.Scan(&key, &ban.Until, &ban.Reason)
+			if err != nil {
+				return fmt.Errorf("[Store] ListBans: scanning row: %v", err)
+			}
+			// string-encode and normalize for API spec.
+			if len(key) == 32 {
+				ban.PubKey = hex.EncodeToString(key)
+			} else {
+				ban.IP = normalizeIP4(Address{Host: net.IP(key)}).Host.String()
+			}
+			bans = append(bans, ban)
+		}
+		if err = rows.Err(); err != nil { // docs say this check is required!
+			return fmt.Errorf("[Store] ListBans: query: %v", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s SQLiteStore) RemoveBans(key []byte) (removed int64, err error) {
+	err = s.doTxn("RemoveBans", func(tx *sql.Tx) error {
+		var res sql.Result
+		var e error
+		if key == nil {
+			res, e = tx.Exec("DELETE FROM ban")
+		} else {
+			res, e = tx.Exec("DELETE FROM ban WHERE key=?", key)
+		}
+		if e != nil {
+			return fmt.Errorf("delete: %v", e)
+		}
+		removed, e = res.RowsAffected()
+		if e != nil {
+			return fmt.Errorf("rows-affected: %v", e)
+		}
 		return nil
 	})
 	return
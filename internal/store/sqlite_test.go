@@ -0,0 +1,440 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/dogenet/internal/spec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+func newTestStore(t *testing.T) spec.Store {
+	t.Helper()
+	db, err := NewSQLiteStore(path.Join(t.TempDir(), "test.db"), context.Background())
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { db.(*SQLiteStore).Close() })
+	return db
+}
+
+func testKey(n byte) []byte {
+	key := make([]byte, 32)
+	key[0] = n
+	return key
+}
+
+func addTestNode(t *testing.T, db spec.Store, n byte, ip string, channels []dnet.Tag4CC) {
+	t.Helper()
+	addr := spec.Address{Host: net.ParseIP(ip), Port: dnet.DogeNetDefaultPort}
+	payload := []byte{n} // must differ per node
+	_, err := db.AddNetNode(testKey(n), addr, time.Now().Unix(), make([]byte, 32), channels, nil, payload, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("AddNetNode: %v", err)
+	}
+}
+
+func keySet(nodes []spec.NodeInfo) map[byte]bool {
+	set := make(map[byte]bool)
+	for _, n := range nodes {
+		set[n.PubKey[0]] = true
+	}
+	return set
+}
+
+func TestSampleNodesByChannel(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", []dnet.Tag4CC{dnet.ChannelIdentity})
+	addTestNode(t, db, 2, "1.2.3.5", []dnet.Tag4CC{dnet.ChannelChat, dnet.ChannelIdentity})
+	addTestNode(t, db, 3, "1.2.3.6", []dnet.Tag4CC{dnet.ChannelChat})
+	addTestNode(t, db, 4, "1.2.3.7", nil)
+
+	res, err := db.SampleNodesByChannel([]dnet.Tag4CC{dnet.ChannelIdentity}, nil)
+	if err != nil {
+		t.Fatalf("SampleNodesByChannel: %v", err)
+	}
+	got := keySet(res)
+	if len(res) != 2 || !got[1] || !got[2] {
+		t.Errorf("expecting nodes 1,2 on [Iden], got: %v", got)
+	}
+
+	res, err = db.SampleNodesByChannel([]dnet.Tag4CC{dnet.ChannelIdentity, dnet.ChannelChat}, [][]byte{testKey(2)})
+	if err != nil {
+		t.Fatalf("SampleNodesByChannel: %v", err)
+	}
+	got = keySet(res)
+	if len(res) != 2 || !got[1] || !got[3] {
+		t.Errorf("expecting nodes 1,3 excluding 2, got: %v", got)
+	}
+
+	res, err = db.SampleNodesByChannel([]dnet.Tag4CC{dnet.ChannelB0rk}, nil)
+	if err != nil {
+		t.Fatalf("SampleNodesByChannel: %v", err)
+	}
+	if len(res) != 0 {
+		t.Errorf("expecting no nodes on [B0rk], got: %v", keySet(res))
+	}
+}
+
+func TestSampleNodesByIP(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", nil)
+	addTestNode(t, db, 2, "1.2.200.5", nil)
+	addTestNode(t, db, 3, "1.3.3.6", nil)
+	addTestNode(t, db, 4, "5.6.7.8", nil)
+	addTestNode(t, db, 5, "2001:db8::1", nil)
+	addTestNode(t, db, 6, "2001:db8:1::1", nil)
+	addTestNode(t, db, 7, "2a00:1450::1", nil)
+
+	res, err := db.SampleNodesByIP(net.ParseIP("1.2.9.9"), nil)
+	if err != nil {
+		t.Fatalf("SampleNodesByIP: %v", err)
+	}
+	got := keySet(res)
+	if len(res) != 5 || got[1] || got[2] {
+		t.Errorf("expecting all nodes outside 1.2.0.0/16, got: %v", got)
+	}
+
+	res, err = db.SampleNodesByIP(net.ParseIP("2001:db8:ffff::2"), [][]byte{testKey(1), testKey(4)})
+	if err != nil {
+		t.Fatalf("SampleNodesByIP: %v", err)
+	}
+	got = keySet(res)
+	if len(res) != 3 || !got[2] || !got[3] || !got[7] {
+		t.Errorf("expecting nodes 2,3,7 outside 2001:db8::/32 excluding 1,4, got: %v", got)
+	}
+
+	_, err = db.SampleNodesByIP(nil, nil)
+	if err == nil {
+		t.Errorf("expecting an error for a nil IP address")
+	}
+}
+
+func TestBans(t *testing.T) {
+	db := newTestStore(t)
+	now := time.Now().Unix()
+	ip := net.ParseIP("1.2.3.4").To16()
+	if err := db.AddBan(testKey(1), now+60, "invalid signature"); err != nil {
+		t.Fatalf("AddBan: %v", err)
+	}
+	if err := db.AddBan(ip, now+60, "private address"); err != nil {
+		t.Fatalf("AddBan: %v", err)
+	}
+	if err := db.AddBan(testKey(2), now-60, "expired"); err != nil {
+		t.Fatalf("AddBan: %v", err)
+	}
+	for _, c := range []struct {
+		key    []byte
+		banned bool
+	}{{testKey(1), true}, {ip, true}, {testKey(2), false}, {testKey(3), false}} {
+		banned, err := db.IsBanned(c.key)
+		if err != nil {
+			t.Fatalf("IsBanned: %v", err)
+		}
+		if banned != c.banned {
+			t.Errorf("IsBanned(%x): expecting %v", c.key, c.banned)
+		}
+	}
+	bans, err := db.ListBans()
+	if err != nil {
+		t.Fatalf("ListBans: %v", err)
+	}
+	if len(bans) != 2 || bans[0].IP != "1.2.3.4" && bans[1].IP != "1.2.3.4" {
+		t.Errorf("expecting two active bans, got: %v", bans)
+	}
+	removed, err := db.RemoveBans(testKey(1))
+	if err != nil || removed != 1 {
+		t.Errorf("RemoveBans: expecting 1 removed, got %v: %v", removed, err)
+	}
+	removed, err = db.RemoveBans(nil)
+	if err != nil || removed != 2 {
+		t.Errorf("RemoveBans(nil): expecting 2 removed, got %v: %v", removed, err)
+	}
+}
+
+func TestChooseNetNodeWeighted(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", nil)
+	addTestNode(t, db, 2, "5.6.7.8", nil)
+	for i := 0; i < 20; i++ {
+		if err := db.RecordConnect(testKey(1), true); err != nil {
+			t.Fatalf("RecordConnect: %v", err)
+		}
+		if err := db.RecordConnect(testKey(2), false); err != nil {
+			t.Fatalf("RecordConnect: %v", err)
+		}
+	}
+	reliable := 0
+	for i := 0; i < 200; i++ {
+		node, err := db.ChooseNetNode()
+		if err != nil {
+			t.Fatalf("ChooseNetNode: %v", err)
+		}
+		if node.PubKey[0] == 1 {
+			reliable++
+		}
+	}
+	// expecting ~86% (including ExploreChance uniform choices)
+	if reliable < 120 || reliable == 200 {
+		t.Errorf("expecting the reliable node to be favoured (but not always chosen): %v/200", reliable)
+	}
+}
+
+// ChooseNetNode chooses nodes in proportion to their weight.
+func TestChooseNetNodeProportions(t *testing.T) {
+	db := newTestStore(t)
+	const reliable = 20
+	for n := byte(1); n <= reliable; n++ {
+		addTestNode(t, db, n, fmt.Sprintf("1.2.3.%v", n), nil)
+		for i := 0; i < 8; i++ { // weight 9/10
+			if err := db.RecordConnect(testKey(n), true); err != nil {
+				t.Fatalf("RecordConnect: %v", err)
+			}
+		}
+	}
+	addTestNode(t, db, 100, "5.6.7.8", nil) // unknown: weight 1/2
+	addTestNode(t, db, 101, "5.6.7.9", nil) // flaky: weight 1/3
+	if err := db.RecordConnect(testKey(101), false); err != nil {
+		t.Fatalf("RecordConnect: %v", err)
+	}
+	const draws = 10000
+	counts := make(map[byte]int)
+	for i := 0; i < draws; i++ {
+		node, err := db.ChooseNetNode()
+		if err != nil {
+			t.Fatalf("ChooseNetNode: %v", err)
+		}
+		counts[node.PubKey[0]]++
+	}
+	total := reliable*0.9 + 0.5 + 1.0/3
+	expect := func(w float64) float64 {
+		return draws * ((1-ExploreChance)*w/total + ExploreChance/(reliable+2))
+	}
+	for _, c := range []struct {
+		name   string
+		got    int
+		expect float64
+	}{
+		{"unknown", counts[100], expect(0.5)},
+		{"flaky", counts[101], expect(1.0 / 3)},
+		{"reliable", draws - counts[100] - counts[101], reliable * expect(0.9)},
+	} {
+		if float64(c.got) < 0.75*c.expect || float64(c.got) > 1.25*c.expect {
+			t.Errorf("%v: expecting about %.0f/%v choices, got %v", c.name, c.expect, draws, c.got)
+		}
+	}
+}
+
+func TestNetStats(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", []dnet.Tag4CC{dnet.ChannelIdentity})
+	addTestNode(t, db, 2, "5.6.7.8", []dnet.Tag4CC{dnet.ChannelIdentity, dnet.ChannelChat})
+	addTestNode(t, db, 3, "2001:db8::1", nil)
+	addr := spec.Address{Host: net.ParseIP("9.9.9.9"), Port: dnet.DogeNetDefaultPort}
+	old := time.Now().Unix() - 2*spec.SecondsPerDay
+	_, err := db.AddNetNode(testKey(4), addr, old, make([]byte, 32), nil, nil, []byte{4}, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("AddNetNode: %v", err)
+	}
+	stats, err := db.NetStats()
+	if err != nil {
+		t.Fatalf("NetStats: %v", err)
+	}
+	if stats.Nodes != 4 || stats.IPv4 != 3 || stats.IPv6 != 1 {
+		t.Errorf("expecting 4 nodes (3 IPv4, 1 IPv6), got: %+v", stats)
+	}
+	if stats.Channels["Iden"] != 2 || stats.Channels["Chat"] != 1 {
+		t.Errorf("expecting 2 [Iden] and 1 [Chat], got: %v", stats.Channels)
+	}
+	if stats.AnnounceAge != (spec.AnnounceAges{Hour: 3, Week: 1}) {
+		t.Errorf("expecting 3 nodes under 1 hour and 1 under 7 days, got: %+v", stats.AnnounceAge)
+	}
+}
+
+func TestQueryNodes(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", []dnet.Tag4CC{dnet.ChannelIdentity})
+	addTestNode(t, db, 2, "2001:db8::1", []dnet.Tag4CC{dnet.ChannelIdentity})
+	addTestNode(t, db, 3, "5.6.7.8", nil)
+	addr := spec.Address{Host: net.ParseIP("9.9.9.9"), Port: dnet.DogeNetDefaultPort}
+	old := time.Now().Unix() - 2*spec.SecondsPerDay
+	_, err := db.AddNetNode(testKey(4), addr, old, testKey(9), nil, nil, []byte{4}, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("AddNetNode: %v", err)
+	}
+	for _, c := range []struct {
+		name   string
+		filter spec.NodeFilter
+		expect []byte
+	}{
+		{"all", spec.NodeFilter{}, []byte{1, 2, 3, 4}},
+		{"channel", spec.NodeFilter{Channel: dnet.ChannelIdentity}, []byte{1, 2}},
+		{"owner", spec.NodeFilter{Owner: testKey(9)}, []byte{4}},
+		{"ipv4", spec.NodeFilter{IPFamily: 4}, []byte{1, 3, 4}},
+		{"ipv6", spec.NodeFilter{IPFamily: 6}, []byte{2}},
+		{"age", spec.NodeFilter{MaxAge: spec.SecondsPerDay}, []byte{1, 2, 3}},
+		{"page1", spec.NodeFilter{Limit: 2}, []byte{1, 2}},
+		{"page2", spec.NodeFilter{Limit: 2, After: testKey(2)}, []byte{3, 4}},
+		{"combined", spec.NodeFilter{IPFamily: 4, MaxAge: spec.SecondsPerDay, After: testKey(1)}, []byte{3}},
+	} {
+		nodes, err := db.QueryNodes(c.filter)
+		if err != nil {
+			t.Fatalf("QueryNodes(%v): %v", c.name, err)
+		}
+		var got []byte
+		for _, n := range nodes {
+			got = append(got, testKeyNum(t, n.PubKey))
+		}
+		if string(got) != string(c.expect) {
+			t.Errorf("QueryNodes(%v): expecting nodes %v, got %v", c.name, c.expect, got)
+		}
+	}
+	if _, err := db.QueryNodes(spec.NodeFilter{IPFamily: 5}); err == nil {
+		t.Errorf("expecting an error for IP family 5")
+	}
+}
+
+func TestGetNetNode(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", nil)
+	rec, err := db.GetNetNode(testKey(1))
+	if err != nil {
+		t.Fatalf("GetNetNode: %v", err)
+	}
+	if string(rec.Payload) != string([]byte{1}) || len(rec.Sig) != 64 {
+		t.Errorf("GetNetNode: unexpected record: %+v", rec)
+	}
+	_, err = db.GetNetNode(testKey(2))
+	if !spec.IsNotFoundError(err) {
+		t.Errorf("GetNetNode: expecting NotFoundError, got: %v", err)
+	}
+}
+
+// testKeyNum returns `n` from a hex-encoded testKey(n)
+func testKeyNum(t *testing.T, pubkey string) byte {
+	t.Helper()
+	key, err := hex.DecodeString(pubkey)
+	if err != nil || len(key) != 32 {
+		t.Fatalf("invalid pubkey: %v", pubkey)
+	}
+	return key[0]
+}
+
+func TestNodeListChannelsServices(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", nil)
+	addr := spec.Address{Host: net.ParseIP("5.6.7.8"), Port: dnet.DogeNetDefaultPort}
+	now := time.Now().Unix()
+	core := node.Service{Tag: dnet.NewTag("Core"), Port: 22556, Data: "mainnet"}
+	channels := []dnet.Tag4CC{dnet.ChannelIdentity, dnet.ChannelChat}
+	_, err := db.AddNetNode(testKey(2), addr, now, make([]byte, 32), channels, []node.Service{core}, []byte{2}, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("AddNetNode: %v", err)
+	}
+	nodes, err := db.NodeList()
+	if err != nil {
+		t.Fatalf("NodeList: %v", err)
+	}
+	for _, n := range nodes {
+		switch testKeyNum(t, n.PubKey) {
+		case 1:
+			if len(n.Channels) != 0 || len(n.Services) != 0 {
+				t.Errorf("expecting no channels or services for node 1, got: %+v", n)
+			}
+		case 2:
+			if len(n.Channels) != 2 || n.Channels[0] != "Chat" || n.Channels[1] != "Iden" {
+				t.Errorf("expecting [Chat] and [Iden] for node 2, got: %v", n.Channels)
+			}
+			if len(n.Services) != 1 || n.Services[0] != (spec.NodeService{Tag: "Core", Port: 22556, Data: "mainnet"}) {
+				t.Errorf("expecting a Core service for node 2, got: %v", n.Services)
+			}
+			if n.LastSeen != now {
+				t.Errorf("expecting lastSeen %v, got: %v", now, n.LastSeen)
+			}
+		}
+	}
+	// re-announcing replaces the services
+	_, err = db.AddNetNode(testKey(2), addr, now, make([]byte, 32), channels, nil, []byte{3}, make([]byte, 64))
+	if err != nil {
+		t.Fatalf("AddNetNode: %v", err)
+	}
+	nodes, err = db.QueryNodes(spec.NodeFilter{After: testKey(1)})
+	if err != nil {
+		t.Fatalf("QueryNodes: %v", err)
+	}
+	if len(nodes) != 1 || len(nodes[0].Services) != 0 {
+		t.Errorf("expecting node 2 without services, got: %+v", nodes)
+	}
+}
+
+// NodeList reads channels and services in batches of maxQueryParams nodes.
+func TestNodeListBatches(t *testing.T) {
+	db := newTestStore(t)
+	count := maxQueryParams + 10
+	now := time.Now().Unix()
+	core := []node.Service{{Tag: dnet.NewTag("Core"), Port: 22556}}
+	for i := 0; i < count; i++ {
+		key := make([]byte, 32)
+		key[0], key[1] = byte(i>>8), byte(i)
+		addr := spec.Address{Host: net.IPv4(1, 2, byte(i>>8), byte(i)), Port: dnet.DogeNetDefaultPort}
+		_, err := db.AddNetNode(key, addr, now, make([]byte, 32), []dnet.Tag4CC{dnet.ChannelChat}, core, key, make([]byte, 64))
+		if err != nil {
+			t.Fatalf("AddNetNode: %v", err)
+		}
+	}
+	nodes, err := db.NodeList()
+	if err != nil {
+		t.Fatalf("NodeList: %v", err)
+	}
+	if len(nodes) != count {
+		t.Fatalf("expecting %v nodes, got %v", count, len(nodes))
+	}
+	for _, n := range nodes {
+		if len(n.Channels) != 1 || n.Channels[0] != "Chat" || len(n.Services) != 1 {
+			t.Fatalf("expecting [Chat] and one service for every node, got: %+v", n)
+		}
+	}
+}
+
+func TestSupersedeNode(t *testing.T) {
+	db := newTestStore(t)
+	addTestNode(t, db, 1, "1.2.3.4", []dnet.Tag4CC{dnet.ChannelChat})
+	isnew, err := db.SupersedeNode(testKey(1), testKey(2), time.Now().Unix(), []byte{2}, make([]byte, 64), false)
+	if err != nil || !isnew {
+		t.Fatalf("SupersedeNode: expecting new handover, got: %v %v", isnew, err)
+	}
+	if _, err = db.GetNetNode(testKey(1)); !spec.IsNotFoundError(err) {
+		t.Errorf("expecting the old node record to be removed, got: %v", err)
+	}
+	isnew, err = db.SupersedeNode(testKey(1), testKey(2), time.Now().Unix(), []byte{2}, make([]byte, 64), false)
+	if err != nil || isnew {
+		t.Errorf("SupersedeNode: expecting known handover, got: %v %v", isnew, err)
+	}
+	// announcements from the old key are ignored.
+	addr := spec.Address{Host: net.ParseIP("1.2.3.4"), Port: dnet.DogeNetDefaultPort}
+	changed, err := db.AddNetNode(testKey(1), addr, time.Now().Unix(), make([]byte, 32), nil, nil, []byte{3}, make([]byte, 64))
+	if err != nil || changed {
+		t.Errorf("AddNetNode: expecting superseded key to be ignored, got: %v %v", changed, err)
+	}
+	// only local handovers are returned.
+	if _, err = db.GetHandover(testKey(2)); !spec.IsNotFoundError(err) {
+		t.Errorf("GetHandover: expecting NotFoundError for a received handover, got: %v", err)
+	}
+	_, err = db.SupersedeNode(testKey(3), testKey(2), time.Now().Unix()-10, []byte{4}, make([]byte, 64), true)
+	if err != nil {
+		t.Fatalf("SupersedeNode: %v", err)
+	}
+	rec, err := db.GetHandover(testKey(2))
+	if err != nil || string(rec.PubKey) != string(testKey(3)) || string(rec.Payload) != string([]byte{4}) {
+		t.Errorf("GetHandover: unexpected record: %+v %v", rec, err)
+	}
+	if _, err = db.GetHandover(testKey(3)); !spec.IsNotFoundError(err) {
+		t.Errorf("GetHandover: expecting NotFoundError, got: %v", err)
+	}
+}
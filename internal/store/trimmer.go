@@ -34,6 +34,7 @@ func (sv *StoreTrimmer) Run() {
 				log.Printf("[store] TrimNodes: day-count has advanced.")
 			}
 			log.Printf("[store] TrimNodes: trimmed %d network nodes", remNode)
+			trimmedNodes.Add(uint64(remNode))
 		}
 	}
 }
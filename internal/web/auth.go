@@ -0,0 +1,158 @@
+package web
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Scope is the access level granted by a bearer token.
+type Scope int
+
+const (
+	ScopeNone  Scope = iota
+	ScopeRead        // GET endpoints
+	ScopeAdmin       // all endpoints, including those that change state
+)
+
+const MinTokenLength = 16
+
+// AuthConfig configures access to the web API.
+//
+// Mutating requests (any method other than GET, HEAD or OPTIONS) always
+// require an admin token. GET requests require a read or admin token
+// once any read token is configured; otherwise they are open.
+type AuthConfig struct {
+	ReadTokens  []string
+	AdminTokens []string
+	CORSOrigins []string // origins allowed to call the API from a browser ("*" for any)
+}
+
+// LoadTokenFile adds tokens from a file with one `<scope> <token>` per line,
+// where scope is `read` or `admin`; blank lines and `#` comments are ignored.
+func (c *AuthConfig) LoadTokenFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("%v:%v: expecting `<scope> <token>`", path, line)
+		}
+		err = c.AddToken(fields[0], fields[1])
+		if err != nil {
+			return fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// AddToken adds a token with scope `read` or `admin`.
+func (c *AuthConfig) AddToken(scope string, token string) error {
+	if len(token) < MinTokenLength {
+		return fmt.Errorf("token is too short (minimum %v characters)", MinTokenLength)
+	}
+	switch scope {
+	case "read":
+		c.ReadTokens = append(c.ReadTokens, token)
+	case "admin":
+		c.AdminTokens = append(c.AdminTokens, token)
+	default:
+		return fmt.Errorf("invalid scope: %v (expecting read or admin)", scope)
+	}
+	return nil
+}
+
+// scopeOf returns the scope granted by the request's bearer token.
+// Browsers cannot set headers on EventSource requests, so the token
+// can also be passed as `?access_token=` (RFC 6750).
+func (c *AuthConfig) scopeOf(r *http.Request) Scope {
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(auth[len("Bearer "):])
+	} else {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return ScopeNone
+	}
+	if matchToken(c.AdminTokens, token) {
+		return ScopeAdmin
+	}
+	if matchToken(c.ReadTokens, token) {
+		return ScopeRead
+	}
+	return ScopeNone
+}
+
+func matchToken(tokens []string, token string) bool {
+	found := false
+	for _, t := range tokens {
+		// compare all tokens in constant time
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// requiredScope returns the scope needed for a request.
+func (c *AuthConfig) requiredScope(r *http.Request) Scope {
+	switch r.Method {
+	case http.MethodOptions:
+		return ScopeNone // CORS preflight cannot carry credentials
+	case http.MethodGet, http.MethodHead:
+		if len(c.ReadTokens) > 0 {
+			return ScopeRead
+		}
+		return ScopeNone
+	default:
+		return ScopeAdmin
+	}
+}
+
+// handler wraps the API with CORS headers and bearer-token checks.
+func (c *AuthConfig) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && c.allowOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Cursor")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			}
+		}
+		need := c.requiredScope(r)
+		if need != ScopeNone {
+			have := c.scopeOf(r)
+			if have == ScopeNone {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="dogenet"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if have < need {
+				http.Error(w, "forbidden: admin token required", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *AuthConfig) allowOrigin(origin string) bool {
+	return slices.Contains(c.CORSOrigins, "*") || slices.Contains(c.CORSOrigins, origin)
+}
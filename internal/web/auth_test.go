@@ -0,0 +1,71 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthScopes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	admin := "admin-token-0123456789"
+	read := "read-token-0123456789"
+	for _, c := range []struct {
+		name   string
+		auth   AuthConfig
+		method string
+		token  string
+		status int
+	}{
+		{"open read", AuthConfig{AdminTokens: []string{admin}}, "GET", "", 200},
+		{"no admin token", AuthConfig{}, "POST", "", 401},
+		{"admin post", AuthConfig{AdminTokens: []string{admin}}, "POST", admin, 200},
+		{"wrong token", AuthConfig{AdminTokens: []string{admin}}, "POST", read, 401},
+		{"read token post", AuthConfig{AdminTokens: []string{admin}, ReadTokens: []string{read}}, "DELETE", read, 403},
+		{"read required", AuthConfig{ReadTokens: []string{read}}, "GET", "", 401},
+		{"read token get", AuthConfig{ReadTokens: []string{read}}, "GET", read, 200},
+		{"admin token get", AuthConfig{AdminTokens: []string{admin}, ReadTokens: []string{read}}, "GET", admin, 200},
+		{"preflight", AuthConfig{ReadTokens: []string{read}}, "OPTIONS", "", 200},
+	} {
+		req := httptest.NewRequest(c.method, "/nodes", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		res := httptest.NewRecorder()
+		c.auth.handler(ok).ServeHTTP(res, req)
+		if res.Code != c.status {
+			t.Errorf("%v: expecting status %v, got %v", c.name, c.status, res.Code)
+		}
+	}
+}
+
+func TestAuthQueryToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	auth := AuthConfig{ReadTokens: []string{"read-token-0123456789"}}
+	req := httptest.NewRequest("GET", "/events?access_token=read-token-0123456789", nil)
+	res := httptest.NewRecorder()
+	auth.handler(ok).ServeHTTP(res, req)
+	if res.Code != 200 {
+		t.Errorf("expecting status 200 with ?access_token, got %v", res.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	auth := AuthConfig{CORSOrigins: []string{"http://pup.local:8080"}}
+	for _, c := range []struct {
+		origin string
+		allow  string
+	}{
+		{"http://pup.local:8080", "http://pup.local:8080"},
+		{"http://evil.example", ""},
+	} {
+		req := httptest.NewRequest("GET", "/nodes", nil)
+		req.Header.Set("Origin", c.origin)
+		res := httptest.NewRecorder()
+		auth.handler(ok).ServeHTTP(res, req)
+		if got := res.Header().Get("Access-Control-Allow-Origin"); got != c.allow {
+			t.Errorf("origin %v: expecting Access-Control-Allow-Origin %q, got %q", c.origin, c.allow, got)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const CertPollInterval = 10 * time.Second // check certificate files for changes
+const SelfSignedCert = "web-cert.pem"     // in the storage directory
+const SelfSignedKey = "web-key.pem"       // in the storage directory
+const SelfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// TLSConfig is the TLS setting for one web API bind (no CertFile: plain HTTP)
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// certLoader serves the certificate from CertFile and KeyFile,
+// reloading it when the files change or on Reload().
+type certLoader struct {
+	conf    TLSConfig
+	cert    atomic.Pointer[tls.Certificate]
+	mutex   sync.Mutex
+	modTime time.Time // latest mod-time of the loaded files (mutex)
+}
+
+func newCertLoader(conf TLSConfig) (*certLoader, error) {
+	l := &certLoader{conf: conf}
+	err := l.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload loads the certificate and key files; the current certificate
+// is kept if they cannot be loaded.
+// called from any
+func (l *certLoader) Reload() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	mod, err := l.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.conf.CertFile, l.conf.KeyFile)
+	if err != nil {
+		return err
+	}
+	l.cert.Store(&cert)
+	l.modTime = mod
+	return nil
+}
+
+// caller holds l.mutex
+func (l *certLoader) filesModTime() (mod time.Time, err error) {
+	for _, name := range []string{l.conf.CertFile, l.conf.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return mod, err
+		}
+		if info.ModTime().After(mod) {
+			mod = info.ModTime()
+		}
+	}
+	return mod, nil
+}
+
+// changed returns true if the files were modified since the last load.
+func (l *certLoader) changed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	mod, err := l.filesModTime()
+	return err == nil && !mod.Equal(l.modTime)
+}
+
+// goroutine
+func (l *certLoader) watch(ctx context.Context, who string) {
+	ticker := time.NewTicker(CertPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if l.changed() {
+				if err := l.Reload(); err != nil {
+					log.Printf("[%s] cannot reload TLS certificate: %v", who, err)
+				} else {
+					log.Printf("[%s] reloaded TLS certificate (file changed)", who)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return l.cert.Load(), nil
+}
+
+// SelfSigned returns a TLSConfig for a self-signed certificate in `dir`,
+// generating the certificate if it doesn't exist yet, and the certificate's
+// SHA-256 fingerprint (for clients to pin).
+func SelfSigned(dir string) (conf TLSConfig, fingerprint string, err error) {
+	conf = TLSConfig{CertFile: path.Join(dir, SelfSignedCert), KeyFile: path.Join(dir, SelfSignedKey)}
+	_, err = os.Stat(conf.CertFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = generateSelfSigned(conf)
+	}
+	if err != nil {
+		return conf, "", err
+	}
+	cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return conf, "", err
+	}
+	return conf, Fingerprint(cert.Certificate[0]), nil
+}
+
+// Fingerprint formats the SHA-256 fingerprint of a DER certificate.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = hex.EncodeToString([]byte{b})
+	}
+	return strings.ToUpper(strings.Join(parts, ":"))
+}
+
+func generateSelfSigned(conf TLSConfig) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("cannot generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("cannot generate serial: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dogenet"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("cannot create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("cannot encode key: %v", err)
+	}
+	// write the key first: the cert file's existence means both exist.
+	err = os.WriteFile(conf.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(conf.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -1,36 +1,64 @@
 package web
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"code.dogecoin.org/dogenet/internal/events"
+	"code.dogecoin.org/dogenet/internal/metrics"
 	"code.dogecoin.org/dogenet/internal/spec"
 	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
 	"code.dogecoin.org/governor"
 )
 
-func New(bind spec.Address, store spec.Store, netSvc spec.NetSvc) governor.Service {
+const MaxNodesLimit = 1000               // maximum page size for /nodes
+const EventsKeepAlive = 30 * time.Second // keep-alive interval for /events
+
+func New(bind spec.Address, store spec.Store, netSvc spec.NetSvc, auth AuthConfig, tlsConf TLSConfig) (*WebAPI, error) {
 	mux := http.NewServeMux()
 	a := &WebAPI{
 		_store: store,
 		srv: http.Server{
 			Addr:    bind.String(),
-			Handler: mux,
+			Handler: auth.handler(mux),
 		},
 		netSvc: netSvc,
 	}
+	if tlsConf.Enabled() {
+		certs, err := newCertLoader(tlsConf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS certificate for %v: %v", bind, err)
+		}
+		a.certs = certs
+		a.srv.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
 
 	mux.HandleFunc("/nodes", a.getNodes)
+	mux.HandleFunc("/nodes/", a.getNode)
 	mux.HandleFunc("/addpeer", a.addpeer)
+	mux.HandleFunc("/bans", a.bans)
+	mux.HandleFunc("/stats", a.getStats)
+	mux.HandleFunc("/announce", a.getAnnounce)
+	mux.HandleFunc("/peers", a.getPeers)
+	mux.HandleFunc("/handlers", a.getHandlers)
+	mux.HandleFunc("/peers/", a.deletePeer)
+	mux.HandleFunc("/metrics", a.getMetrics)
+	mux.HandleFunc("/events", a.getEvents)
 
-	return a
+	return a, nil
 }
 
 type WebAPI struct {
@@ -39,6 +67,7 @@ type WebAPI struct {
 	store  spec.Store
 	srv    http.Server
 	netSvc spec.NetSvc
+	certs  *certLoader // nil: plain HTTP
 }
 
 // called on any
@@ -55,15 +84,45 @@ func (a *WebAPI) Stop() {
 // goroutine
 func (a *WebAPI) Run() {
 	a.store = a._store.WithCtx(a.Context) // Service Context is first available here
+	if a.certs != nil {
+		go a.certs.watch(a.Context, a.srv.Addr)
+		log.Printf("HTTPS server listening on: %v\n", a.srv.Addr)
+		if err := a.srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed { // blocking call
+			log.Printf("HTTPS server: %v\n", err)
+		}
+		return
+	}
 	log.Printf("HTTP server listening on: %v\n", a.srv.Addr)
 	if err := a.srv.ListenAndServe(); err != http.ErrServerClosed { // blocking call
 		log.Printf("HTTP server: %v\n", err)
 	}
 }
 
+// ReloadTLS reloads the TLS certificate files (e.g. on SIGHUP)
+// called from any
+func (a *WebAPI) ReloadTLS() {
+	if a.certs == nil {
+		return
+	}
+	if err := a.certs.Reload(); err != nil {
+		log.Printf("[%s] cannot reload TLS certificate: %v", a.srv.Addr, err)
+	} else {
+		log.Printf("[%s] reloaded TLS certificate", a.srv.Addr)
+	}
+}
+
+// GET /nodes lists known nodes, ordered by pubkey.
+// Optional filters: ?channel=<4cc>&owner=<pubkey-hex>&ip=<4|6>&age=<seconds>
+// Pagination: ?limit=<n>&cursor=<pubkey-hex>; when there may be more nodes,
+// the cursor for the next page is returned in the X-Cursor header.
 func (a *WebAPI) getNodes(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		nodes, err := a.store.NodeList()
+		filter, err := parseNodeFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nodes, err := a.store.QueryNodes(filter)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
 			return
@@ -72,17 +131,255 @@ func (a *WebAPI) getNodes(w http.ResponseWriter, r *http.Request) {
 			// Go incorrectly encodes this as `null`
 			nodes = make([]spec.NetNode, 0)
 		}
-		bytes, err := json.Marshal(nodes)
+		if filter.Limit > 0 && len(nodes) == filter.Limit {
+			w.Header().Set("X-Cursor", nodes[len(nodes)-1].PubKey)
+		}
+		sendJson(w, nodes, "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+func parseNodeFilter(q url.Values) (filter spec.NodeFilter, err error) {
+	if channel := q.Get("channel"); channel != "" {
+		if len(channel) != 4 {
+			return filter, fmt.Errorf("invalid channel: expecting a 4-character tag")
+		}
+		filter.Channel = dnet.NewTag(channel)
+	}
+	if owner := q.Get("owner"); owner != "" {
+		filter.Owner, err = hex.DecodeString(owner)
+		if err != nil || len(filter.Owner) != 32 {
+			return filter, fmt.Errorf("invalid owner: expecting a hex pubkey")
+		}
+	}
+	switch q.Get("ip") {
+	case "":
+	case "4":
+		filter.IPFamily = 4
+	case "6":
+		filter.IPFamily = 6
+	default:
+		return filter, fmt.Errorf("invalid ip: expecting 4 or 6")
+	}
+	if age := q.Get("age"); age != "" {
+		filter.MaxAge, err = strconv.ParseInt(age, 10, 64)
+		if err != nil || filter.MaxAge < 1 {
+			return filter, fmt.Errorf("invalid age: expecting seconds")
+		}
+	}
+	if limit := q.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > MaxNodesLimit {
+			return filter, fmt.Errorf("invalid limit: expecting 1 to %v", MaxNodesLimit)
+		}
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		filter.After, err = hex.DecodeString(cursor)
+		if err != nil || len(filter.After) != 32 {
+			return filter, fmt.Errorf("invalid cursor: expecting a hex pubkey")
+		}
+	}
+	return filter, nil
+}
+
+// GET /nodes/<pubkey-hex> returns the node's decoded announcement.
+func (a *WebAPI) getNode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		pub, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/nodes/"))
+		if err != nil || len(pub) != 32 {
+			http.Error(w, "invalid node: expecting a hex pubkey", http.StatusBadRequest)
+			return
+		}
+		rec, err := a.store.GetNetNode(pub)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("error encoding JSON: %s", err.Error()), http.StatusInternalServerError)
+			if spec.IsNotFoundError(err) {
+				http.Error(w, "node not found", http.StatusNotFound)
+			} else {
+				http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			}
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
+		detail, err := decodeNode(rec)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, detail, "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+// decodeNode decodes the stored [Node][Addr] announcement.
+func decodeNode(rec spec.NodeRecord) (detail spec.NodeDetail, err error) {
+	defer func() {
+		if e := recover(); e != nil { // for DecodeAddrMsg
+			err = fmt.Errorf("invalid stored announcement: %v", e)
+		}
+	}()
+	msg := node.DecodeAddrMsg(rec.Payload)
+	addr := dnet.Address{Host: net.IP(msg.Address), Port: msg.Port}
+	if ip4 := addr.Host.To4(); ip4 != nil {
+		addr.Host = ip4
+	}
+	owner := ""
+	if !bytes.Equal(msg.Owner, make([]byte, 32)) {
+		owner = hex.EncodeToString(msg.Owner)
+	}
+	detail = spec.NodeDetail{
+		PubKey:   hex.EncodeToString(rec.PubKey),
+		Address:  addr.String(),
+		Time:     msg.Time.Local().Unix(),
+		Owner:    owner,
+		Channels: make([]string, 0, len(msg.Channels)),
+		Services: make([]spec.NodeService, 0, len(msg.Services)),
+		Payload:  hex.EncodeToString(rec.Payload),
+		Sig:      hex.EncodeToString(rec.Sig),
+	}
+	for _, ch := range msg.Channels {
+		detail.Channels = append(detail.Channels, ch.String())
+	}
+	for _, svc := range msg.Services {
+		detail.Services = append(detail.Services, spec.NodeService{Tag: svc.Tag.String(), Port: svc.Port, Data: svc.Data})
+	}
+	return detail, nil
+}
+
+// GET /announce returns this node's current decoded announcement.
+func (a *WebAPI) getAnnounce(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		msg := a.netSvc.GetAnnounce()
+		if len(msg.Header) == 0 {
+			http.Error(w, "no announcement yet", http.StatusNotFound)
+			return
+		}
+		view := dnet.MsgView(msg.Header)
+		detail, err := decodeNode(spec.NodeRecord{PubKey: view.PubKey()[:], Payload: msg.Payload, Sig: view.Signature()[:]})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, detail, "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+type Stats struct {
+	Nodes   spec.NetStats     `json:"nodes"`   // node database
+	Service spec.ServiceStats `json:"service"` // live connections
+}
+
+func (a *WebAPI) getStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		nodes, err := a.store.NetStats()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		sendJson(w, Stats{Nodes: nodes, Service: a.netSvc.Stats()}, "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+// getMetrics serves metrics in Prometheus text format.
+func (a *WebAPI) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		var buf bytes.Buffer
+		metrics.WriteText(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 		w.Header().Set("Allow", "GET, OPTIONS")
-		w.Write(bytes)
+		w.Write(buf.Bytes())
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+// GET /peers lists live peer connections.
+func (a *WebAPI) getPeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		sendJson(w, a.netSvc.Peers(), "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+// GET /handlers lists connected protocol handlers.
+func (a *WebAPI) getHandlers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		sendJson(w, a.netSvc.Handlers(), "GET, OPTIONS")
+	} else {
+		options(w, r, "GET, OPTIONS")
+	}
+}
+
+// DELETE /peers/<pubkey-hex> disconnects a peer.
+func (a *WebAPI) deletePeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		pub, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/peers/"))
+		if err != nil || len(pub) != 32 {
+			http.Error(w, "invalid peer: expecting a hex pubkey", http.StatusBadRequest)
+			return
+		}
+		if !a.netSvc.DisconnectPeer(([32]byte)(pub)) {
+			http.Error(w, "not connected to peer", http.StatusNotFound)
+			return
+		}
+		sendJson(w, "OK", "DELETE, OPTIONS")
 	} else {
+		options(w, r, "DELETE, OPTIONS")
+	}
+}
+
+// GET /events streams network changes as Server-Sent Events.
+// Each event is sent as `event: <type>` with the JSON-encoded events.Event as data.
+func (a *WebAPI) getEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		options(w, r, "GET, OPTIONS")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub := events.Subscribe(events.DefaultBuffer)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Allow", "GET, OPTIONS")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(EventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-sub.C:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("[events] error encoding JSON: %v", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			if err != nil {
+				return // client went away
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			// comment line: keeps proxies from closing an idle stream
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-a.Context.Done():
+			return // shutting down
+		}
 	}
 }
 
@@ -136,6 +433,59 @@ func (a *WebAPI) addpeer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /bans lists active bans.
+// DELETE /bans removes all bans, or DELETE /bans?ban=<pubkey-hex or ip> removes one.
+func (a *WebAPI) bans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bans, err := a.store.ListBans()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if bans == nil {
+			// Go incorrectly encodes this as `null`
+			bans = make([]spec.BanInfo, 0)
+		}
+		sendJson(w, bans, "GET, DELETE, OPTIONS")
+	case http.MethodDelete:
+		var key []byte // nil: remove all bans
+		if ban := r.URL.Query().Get("ban"); ban != "" {
+			if ip := net.ParseIP(ban); ip != nil {
+				key = ip.To16()
+			} else {
+				pub, err := hex.DecodeString(ban)
+				if err != nil || len(pub) != 32 {
+					http.Error(w, "invalid ban: expecting a hex pubkey or IP address", http.StatusBadRequest)
+					return
+				}
+				key = pub
+			}
+		}
+		removed, err := a.store.RemoveBans(key)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error in query: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("removed %v bans", removed)
+		sendJson(w, map[string]int64{"removed": removed}, "GET, DELETE, OPTIONS")
+	default:
+		options(w, r, "GET, DELETE, OPTIONS")
+	}
+}
+
+func sendJson(w http.ResponseWriter, val any, options string) {
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding JSON: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
+	w.Header().Set("Allow", options)
+	w.Write(bytes)
+}
+
 func options(w http.ResponseWriter, r *http.Request, options string) {
 	switch r.Method {
 	case http.MethodOptions: